@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ACCESS_LOG_COMBINED = "combined"
+	ACCESS_LOG_JSON     = "json"
+)
+
+// query parameters masked in access logs
+var accessLogSecretParams = []string{"secret", "new_secret"}
+
+// AccessLogger writes one line per request, apart from the logs of the app
+type AccessLogger struct {
+	out           io.Writer
+	format        string
+	sampledRoutes map[string]bool
+	sampleRate    float64
+
+	// for test
+	random func() float64
+}
+
+// accessLogEntry is a json line of the access log
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	RemoteAddr string  `json:"remote_addr"`
+	Method     string  `json:"method"`
+	Uri        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	Referer    string  `json:"referer"`
+	UserAgent  string  `json:"user_agent"`
+	Namespace  string  `json:"namespace"`
+	Key        string  `json:"key"`
+	Latency    float64 `json:"latency"`
+	RequestId  string  `json:"request_id"`
+}
+
+// NewAccessLogger returns nil if the access log is disabled, and writes
+// to stdout if the log directory is not writable
+func NewAccessLogger(config AccessLogConfig, logConfig LogConfig) *AccessLogger {
+	if !config.Enabled {
+		return nil
+	}
+	var out io.Writer = os.Stdout
+	writer, err := newLogWriter(path.Join(logConfig.Path, config.Name), logConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "access log to stdout. err:", err)
+	} else {
+		out = writer
+	}
+	sampledRoutes := make(map[string]bool)
+	for _, route := range config.SampledRoutes {
+		sampledRoutes[route] = true
+	}
+	return &AccessLogger{
+		out:           out,
+		format:        config.Format,
+		sampledRoutes: sampledRoutes,
+		sampleRate:    config.SampleRate,
+		random:        rand.Float64,
+	}
+}
+
+// redactUri masks secrets in the query of uri
+func redactUri(uri string) string {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil || u.RawQuery == "" {
+		return uri
+	}
+	query := u.Query()
+	redacted := false
+	for _, name := range accessLogSecretParams {
+		if query.Has(name) {
+			query.Set(name, "xxxxx")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return uri
+	}
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
+
+// escapeLogField escapes quotes, backslashes and control characters like nginx
+func escapeLogField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' || c == '\\' || c < 0x20 || c >= 0x7f {
+			fmt.Fprintf(&b, "\\x%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// sampled tells whether the request should be logged, only successful
+// requests of the sampled routes are dropped
+func (accessLog *AccessLogger) sampled(route string, status int) bool {
+	if !accessLog.sampledRoutes[route] || status >= 400 {
+		return true
+	}
+	return accessLog.random() < accessLog.sampleRate
+}
+
+// Log writes the line of a finished request
+func (accessLog *AccessLogger) Log(c *gin.Context, start time.Time, latency time.Duration) {
+	status := c.Writer.Status()
+	if !accessLog.sampled(c.FullPath(), status) {
+		return
+	}
+	size := c.Writer.Size()
+	if size < 0 {
+		size = 0
+	}
+	entry := accessLogEntry{
+		Time:       start.Format(time.RFC3339Nano),
+		RemoteAddr: c.ClientIP(),
+		Method:     c.Request.Method,
+		Uri:        redactUri(c.Request.URL.RequestURI()),
+		Proto:      c.Request.Proto,
+		Status:     status,
+		Bytes:      size,
+		Referer:    c.Request.Referer(),
+		UserAgent:  c.Request.UserAgent(),
+		Namespace:  c.Query("namespace"),
+		Key:        c.Query("key"),
+		Latency:    latency.Seconds(),
+		RequestId:  requestIDFromContext(c.Request.Context()),
+	}
+
+	var b bytes.Buffer
+	if accessLog.format == ACCESS_LOG_JSON {
+		if err := json.NewEncoder(&b).Encode(entry); err != nil {
+			return
+		}
+	} else {
+		// combined log format, with namespace, key, latency and request id after
+		bytesField := "-"
+		if size > 0 {
+			bytesField = strconv.Itoa(size)
+		}
+		fmt.Fprintf(&b, "%s - - [%s] \"%s %s %s\" %d %s \"%s\" \"%s\" \"%s\" \"%s\" %.6f %s\n",
+			escapeLogField(entry.RemoteAddr), start.Format("02/Jan/2006:15:04:05 -0700"),
+			escapeLogField(entry.Method), escapeLogField(entry.Uri), escapeLogField(entry.Proto),
+			status, bytesField, escapeLogField(entry.Referer), escapeLogField(entry.UserAgent),
+			escapeLogField(entry.Namespace), escapeLogField(entry.Key), entry.Latency, escapeLogField(entry.RequestId))
+	}
+	// one write per line, so lines of concurrent requests don't interleave
+	accessLog.out.Write(b.Bytes())
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRedactUri(t *testing.T) {
+	for uri, redacted := range map[string]string{
+		"/pv/get?namespace=a&key=b":                 "/pv/get?namespace=a&key=b",
+		"/pv/reset?namespace=a&secret=world":        "/pv/reset?namespace=a&secret=xxxxx",
+		"/pv/namespace/rotate?new_secret=s&secret=": "/pv/namespace/rotate?new_secret=xxxxx&secret=xxxxx",
+		"/ping": "/ping",
+	} {
+		if got := redactUri(uri); got != redacted {
+			t.Errorf("uri %s should be redacted to %s, got %s", uri, redacted, got)
+		}
+	}
+}
+
+func TestEscapeLogField(t *testing.T) {
+	if escapeLogField("") != "-" || escapeLogField(`a "b"`+"\n") != `a \x22b\x22\x0A` {
+		t.Fail()
+	}
+}
+
+func MockAccessLogRouter(accessLog *AccessLogger) *gin.Engine {
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.Use(LoggerMiddleware(MockNewLogger(), accessLog))
+	r.GET("/pv/get", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	r.POST("/pv/increment", func(c *gin.Context) {
+		if c.Query("fail") == "1" {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.String(http.StatusOK, "1")
+	})
+	return r
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	accessLog := &AccessLogger{out: &buf, format: ACCESS_LOG_COMBINED, random: func() float64 { return 0 }}
+	router := MockAccessLogRouter(accessLog)
+	defer CleanLog()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/pv/get?namespace=ns&key=page&secret=world", nil)
+	req.Header.Set("Referer", "https://example.com/post")
+	req.Header.Set("User-Agent", `Mozilla/5.0 "test"`)
+	req.Header.Set("X-Request-ID", "req-1")
+	req.RemoteAddr = "10.0.0.1:1234"
+	router.ServeHTTP(w, req)
+
+	pattern := regexp.MustCompile(`^\S+ - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] ` +
+		`"GET /pv/get\?key=page&namespace=ns&secret=xxxxx HTTP/1.1" 200 5 "https://example.com/post" ` +
+		`"Mozilla/5.0 \\x22test\\x22" "ns" "page" \d+\.\d{6} req-1\n$`)
+	if !pattern.MatchString(buf.String()) {
+		t.Errorf("unexpected access log %q", buf.String())
+	}
+
+	buf.Reset()
+	accessLog.format = ACCESS_LOG_JSON
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/pv/increment?namespace=ns&key=page", nil)
+	router.ServeHTTP(w, req)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if entry["method"] != "POST" || entry["status"] != float64(200) || entry["bytes"] != float64(1) ||
+		entry["namespace"] != "ns" || entry["key"] != "page" || entry["request_id"] == "" {
+		t.Errorf("unexpected access log %s", buf.String())
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	var buf bytes.Buffer
+	accessLog := &AccessLogger{
+		out:           &buf,
+		format:        ACCESS_LOG_JSON,
+		sampledRoutes: map[string]bool{"/pv/increment": true},
+		sampleRate:    0.5,
+		random:        func() float64 { return 0.9 },
+	}
+	router := MockAccessLogRouter(accessLog)
+	defer CleanLog()
+
+	for _, item := range []struct {
+		method string
+		url    string
+		logged bool
+	}{
+		{"POST", "/pv/increment?namespace=ns&key=page", false},
+		{"POST", "/pv/increment?namespace=ns&key=page&fail=1", true},
+		{"GET", "/pv/get?namespace=ns&key=page", true},
+	} {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(item.method, item.url, nil)
+		router.ServeHTTP(w, req)
+		if (buf.Len() > 0) != item.logged {
+			t.Errorf("%s %s should be logged %v", item.method, item.url, item.logged)
+		}
+	}
+}
+
+func TestNewAccessLogger(t *testing.T) {
+	config := DefaultConfig()
+	config.Log.Path = t.TempDir()
+	if NewAccessLogger(AccessLogConfig{Enabled: false}, config.Log) != nil {
+		t.Fail()
+	}
+	accessLog := NewAccessLogger(config.AccessLog, config.Log)
+	router := MockAccessLogRouter(accessLog)
+	defer CleanLog()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/pv/get?namespace=ns&key=page", nil)
+	router.ServeHTTP(w, req)
+	content, err := os.ReadFile(path.Join(config.Log.Path, config.AccessLog.Name))
+	if err != nil || !strings.Contains(string(content), `"GET /pv/get?namespace=ns&key=page HTTP/1.1" 200`) {
+		t.Fail()
+	}
+	// not in the log of the app
+	content, _ = os.ReadFile(path.Join(config.Log.Path, config.Log.Name))
+	if strings.Contains(string(content), "/pv/get") {
+		t.Fail()
+	}
+}
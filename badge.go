@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// colors of shields.io
+var badgeColors = map[string]string{
+	"brightgreen":   "#4c1",
+	"green":         "#97ca00",
+	"yellowgreen":   "#a4a61d",
+	"yellow":        "#dfb317",
+	"orange":        "#fe7d37",
+	"red":           "#e05d44",
+	"blue":          "#007ec6",
+	"grey":          "#555",
+	"gray":          "#555",
+	"lightgrey":     "#9f9f9f",
+	"lightgray":     "#9f9f9f",
+	"blueviolet":    "#8a2be2",
+	"success":       "#4c1",
+	"important":     "#fe7d37",
+	"critical":      "#e05d44",
+	"informational": "#007ec6",
+	"inactive":      "#9f9f9f",
+}
+
+var hexColorRegexp = regexp.MustCompile(`^([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// width of ascii characters in Verdana 11px, starting from space
+var verdanaWidths = []float64{
+	3.87, 4.33, 5.05, 9.00, 6.99, 11.84, 7.99, 2.95, 4.99, 4.99, 6.99, 9.00, 4.00, 4.99, 4.00, 4.99,
+	6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 4.99, 4.99, 9.00, 9.00, 9.00, 6.00,
+	11.00, 7.52, 7.54, 7.68, 8.48, 6.96, 6.32, 8.53, 8.27, 4.63, 5.00, 7.62, 6.12, 9.27, 8.23, 8.66,
+	6.63, 8.66, 7.65, 7.52, 6.78, 8.05, 7.52, 10.88, 7.54, 6.77, 7.54, 4.99, 4.99, 4.99, 9.00, 6.99,
+	6.99, 6.61, 6.85, 5.73, 6.85, 6.55, 3.87, 6.85, 6.96, 3.02, 3.79, 6.51, 3.02, 10.70, 6.96, 6.68,
+	6.85, 6.85, 4.69, 5.73, 4.33, 6.96, 6.51, 8.98, 6.51, 6.51, 5.78, 6.98, 4.99, 6.98, 9.00,
+}
+
+// textWidth measures text in Verdana 11px like shields.io
+func textWidth(text string) float64 {
+	width := 0.0
+	for _, r := range text {
+		switch {
+		case r >= ' ' && r <= '~':
+			width += verdanaWidths[r-' ']
+		case r >= 0x2e80:
+			// CJK and other wide characters take a full em
+			width += 11
+		default:
+			width += verdanaWidths['M'-' ']
+		}
+	}
+	return width
+}
+
+func badgeColor(color string) string {
+	if value, ok := badgeColors[color]; ok {
+		return value
+	}
+	if hexColorRegexp.MatchString(color) {
+		return "#" + color
+	}
+	return badgeColors[BADGE_DEFAULT_COLOR]
+}
+
+// renderBadge renders a shields.io-like badge of style `flat` or `flat-square`
+func renderBadge(label, message, color, style string) string {
+	labelWidth := int(textWidth(label)+0.5) + 10
+	messageWidth := int(textWidth(message)+0.5) + 10
+	width := labelWidth + messageWidth
+	labelX := float64(labelWidth) / 2
+	messageX := float64(labelWidth) + float64(messageWidth)/2
+	label = html.EscapeString(label)
+	message = html.EscapeString(message)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`,
+		width, label, message)
+	fmt.Fprintf(&b, `<title>%s: %s</title>`, label, message)
+	if style == "flat-square" {
+		fmt.Fprintf(&b, `<g shape-rendering="crispEdges"><rect width="%d" height="20" fill="#555"/>`, labelWidth)
+		fmt.Fprintf(&b, `<rect x="%d" width="%d" height="20" fill="%s"/></g>`, labelWidth, messageWidth, badgeColor(color))
+	} else {
+		b.WriteString(`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+		fmt.Fprintf(&b, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`, width)
+		fmt.Fprintf(&b, `<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/>`, labelWidth)
+		fmt.Fprintf(&b, `<rect x="%d" width="%d" height="20" fill="%s"/>`, labelWidth, messageWidth, badgeColor(color))
+		fmt.Fprintf(&b, `<rect width="%d" height="20" fill="url(#s)"/></g>`, width)
+	}
+	b.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" text-rendering="geometricPrecision" font-size="11">`)
+	for _, text := range []struct {
+		x     float64
+		value string
+	}{{labelX, label}, {messageX, message}} {
+		if style != "flat-square" {
+			fmt.Fprintf(&b, `<text x="%.1f" y="15" fill="#010101" fill-opacity=".3">%s</text>`, text.x, text.value)
+		}
+		fmt.Fprintf(&b, `<text x="%.1f" y="14">%s</text>`, text.x, text.value)
+	}
+	b.WriteString(`</g></svg>`)
+	return b.String()
+}
+
+func GetPvBadge(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "badge_pv")
+
+	namespace := c.Query("namespace")
+	key := c.Query("key")
+	if ok := checkNamespaceAndKey(namespace, key, c); !ok {
+		return
+	}
+	if ok := isNamespaceValid(namespace, c); !ok {
+		return
+	}
+
+	readonly := c.Query("readonly") == "1"
+	var value interface{}
+	if readonly {
+		settings, ok := isReadAllowed(namespace, false, c)
+		if !ok {
+			return
+		}
+		result, err := G_db.Get(ctx, constructKey(namespace, key))
+		if err == nil {
+			err = settings.expireKeys(ctx, result.key)
+		}
+		switch {
+		case err == nil:
+			value = result.value
+		case strings.Contains(err.Error(), "does not exist"):
+			value = 0
+		default:
+			G_logger.WithContext(ctx).Warn(err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		var result *RedisResult
+		var skipped string
+		hidden := false
+		settings, err := getSettings(ctx, namespace)
+		if err == nil {
+			hidden = !canRead(settings, namespace, c)
+			skipped, err = checkHit(settings, namespace, key, c)
+		}
+		if err == nil && skipped == "" {
+			result, _, err = incrementPv(ctx, settings, namespace, key, getVisitorId(c))
+		} else if err == nil && !hidden {
+			result, err = getPvValue(ctx, namespace, key)
+		}
+		// like IncrementPv, the hit is counted but no badge is drawn
+		if hidden {
+			respondHidden(err, c)
+			return
+		}
+		if errors.Is(err, errTooManyKeys) {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			G_logger.WithContext(ctx).Warn(err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		value = result.value
+	}
+
+	label := c.DefaultQuery("label", BADGE_DEFAULT_LABEL)
+	svg := renderBadge(label, fmt.Sprint(value), c.DefaultQuery("color", BADGE_DEFAULT_COLOR), c.Query("style"))
+	sum := sha1.Sum([]byte(svg))
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	c.Header("ETag", etag)
+	if readonly {
+		c.Header("Cache-Control", "public, max-age="+strconv.Itoa(BADGE_CACHE_MAX_AGE))
+	} else {
+		// every load should reach us to be counted
+		c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
+		c.Header("Expires", "0")
+	}
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "image/svg+xml; charset=utf-8", []byte(svg))
+}
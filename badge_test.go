@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTextWidth(t *testing.T) {
+	if width := textWidth("views"); width < 29 || width > 31 {
+		fmt.Println(width)
+		t.Fail()
+	}
+	if textWidth("iii") >= textWidth("WWW") {
+		t.Fail()
+	}
+	if textWidth("访问") != 22 {
+		t.Fail()
+	}
+}
+
+func TestBadgeColor(t *testing.T) {
+	if badgeColor("green") != "#97ca00" || badgeColor("ff0000") != "#ff0000" ||
+		badgeColor("abc") != "#abc" || badgeColor("<script>") != "#007ec6" {
+		t.Fail()
+	}
+}
+
+func TestRenderBadge(t *testing.T) {
+	svg := renderBadge("<views>", "10", "red", "")
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, "&lt;views&gt;") ||
+		strings.Contains(svg, "<views>") || !strings.Contains(svg, "#e05d44") || !strings.Contains(svg, `rx="3"`) {
+		fmt.Println(svg)
+		t.Fail()
+	}
+	svg = renderBadge("views", "10", "red", "flat-square")
+	if strings.Contains(svg, `rx="3"`) || strings.Contains(svg, "fill-opacity") {
+		fmt.Println(svg)
+		t.Fail()
+	}
+}
+
+func TestGetPvBadge(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/pv/create?namespace=badgetest", nil)
+	router.ServeHTTP(w, req)
+	defer func() {
+		for _, pattern := range []string{"history@badgetest@*", "top@badgetest*", "key@badgetest@*"} {
+			keys, _ := G_db.GetPrefixMatchKeys(ctx, pattern)
+			G_db.Delete(ctx, keys...)
+		}
+		G_db.Delete(ctx, "namespace@badgetest", "settings@badgetest", "call@create_pv", "call@badge_pv")
+	}()
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/badge/pv.svg?namespace=none&key=page", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Fail()
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/badge/pv.svg?namespace=badgetest&key=page&readonly=1", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "views: 0") ||
+		!strings.Contains(w.Header().Get("Cache-Control"), "max-age") {
+		fmt.Println(w.Body.String())
+		t.Fail()
+	}
+
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/badge/pv.svg?namespace=badgetest&key=page&label=hits", nil)
+		router.ServeHTTP(w, req)
+	}
+	if w.Code != 200 || w.Header().Get("Content-Type") != "image/svg+xml; charset=utf-8" ||
+		!strings.Contains(w.Body.String(), "hits: 2") || !strings.Contains(w.Header().Get("Cache-Control"), "no-cache") {
+		fmt.Println(w.Body.String())
+		t.Fail()
+	}
+
+	// increments through the same path as IncrementPv
+	result, err := G_db.Get(ctx, "key@badgetest@page")
+	if err != nil || result.value != "2" {
+		t.Fail()
+	}
+	history, _ := G_db.GetPrefixMatchKeys(ctx, "history@badgetest@*")
+	if len(history) != 3 {
+		t.Fail()
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/badge/pv.svg?namespace=badgetest&key=page&readonly=1", nil)
+	router.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/badge/pv.svg?namespace=badgetest&key=page&readonly=1", nil)
+	req.Header.Set("If-None-Match", etag)
+	router.ServeHTTP(w, req)
+	if etag == "" || w.Code != 304 || w.Body.Len() != 0 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type batchIncrementItem struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	// default to 1, others need the reset scope like `/pv/increment?by=N`
+	By int64 `json:"by"`
+}
+
+type batchIncrementRequest struct {
+	Items []batchIncrementItem `json:"items"`
+}
+
+// parseBatchIncrementItems returns the valid items of the body, nothing if
+// the body is invalid. The body is kept for the handler to bind again
+func parseBatchIncrementItems(c *gin.Context) []batchIncrementItem {
+	var request batchIncrementRequest
+	if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil || len(request.Items) > BATCH_MAX_ITEMS {
+		return nil
+	}
+	items := make([]batchIncrementItem, 0)
+	for _, item := range request.Items {
+		if item.Namespace != "" && !strings.Contains(item.Namespace, "@") && item.Key != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// batchResult is the value of every item in Data
+type batchResult struct {
+	Value   int64  `json:"value"`
+	Counted bool   `json:"counted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// batchChecker checks namespaces and credentials of items,
+// each namespace and scope is checked once
+type batchChecker struct {
+	c        *gin.Context
+	checks   map[string]string
+	settings map[string]*namespaceSettings
+}
+
+func newBatchChecker(c *gin.Context) *batchChecker {
+	return &batchChecker{
+		c:        c,
+		checks:   make(map[string]string),
+		settings: make(map[string]*namespaceSettings),
+	}
+}
+
+// loadSettings returns the settings of a valid namespace, loaded once
+func (checker *batchChecker) loadSettings(ctx context.Context, namespace string) (*namespaceSettings, error) {
+	if settings, ok := checker.settings[namespace]; ok {
+		return settings, nil
+	}
+	if _, err := G_db.Get(ctx, constructNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	settings, err := getSettings(ctx, namespace)
+	if err == nil {
+		checker.settings[namespace] = settings
+	}
+	return settings, err
+}
+
+// check returns the error of namespace for scope, "" if ok
+func (checker *batchChecker) check(namespace, scope string) string {
+	ctx := checker.c.Request.Context()
+	if result, ok := checker.checks[namespace+"@"+scope]; ok {
+		return result
+	}
+	result := ""
+	settings, err := checker.loadSettings(ctx, namespace)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		result = "invalid namespace"
+	} else {
+		// like isTokenAllowed, the token is optional for reads of public
+		// namespaces and increments
+		optional := scope == SCOPE_INCREMENT || (scope == SCOPE_READ && settings.Visibility == VISIBILITY_PUBLIC)
+		if (!optional || getBearerToken(checker.c) != "") && !checkCredential(namespace, scope, checker.c) {
+			result = "authentication failed"
+			// like isReadAllowed, a private namespace looks like it doesn't exist
+			if scope == SCOPE_READ && settings.Visibility == VISIBILITY_PRIVATE {
+				result = "invalid namespace"
+			}
+		}
+	}
+	checker.checks[namespace+"@"+scope] = result
+	return result
+}
+
+// hidden tells whether namespace is private and the caller can't read it,
+// its items are counted but look like the namespace doesn't exist
+func (checker *batchChecker) hidden(namespace string) bool {
+	settings, ok := checker.settings[namespace]
+	if !ok || settings.Visibility != VISIBILITY_PRIVATE {
+		return false
+	}
+	return checker.check(namespace, SCOPE_READ) != ""
+}
+
+// expireKeys applies the key ttl of each namespace to keys of items
+func (checker *batchChecker) expireKeys(ctx context.Context, namespaces, keys []string) {
+	grouped := make(map[string][]string)
+	for index, namespace := range namespaces {
+		grouped[namespace] = append(grouped[namespace], keys[index])
+	}
+	for namespace, keys := range grouped {
+		if err := checker.settings[namespace].expireKeys(ctx, keys...); err != nil {
+			G_logger.WithContext(ctx).Warn(err)
+		}
+	}
+}
+
+// countBatchItem handles the allowed origins, the bot filter and the dedup
+// window of a view, return whether it should be incremented. The value
+// of a skipped view isn't read if hidden
+func countBatchItem(item batchIncrementItem, visitor string, settings *namespaceSettings, hidden bool, c *gin.Context) (batchResult, bool) {
+	ctx := c.Request.Context()
+	if item.By != 1 {
+		return batchResult{}, true
+	}
+	skipped, err := checkHit(settings, item.Namespace, item.Key, c)
+	if err == nil && skipped == "" {
+		var duplicate bool
+		if duplicate, err = isDuplicateHit(ctx, item.Namespace, item.Key, visitor, settings.dedupWindow()); err == nil && !duplicate {
+			return batchResult{}, true
+		}
+	}
+	var result *RedisResult
+	if err == nil && hidden {
+		return batchResult{}, false
+	}
+	if err == nil {
+		result, err = getPvValue(ctx, item.Namespace, item.Key)
+	}
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		return batchResult{Error: "internal error"}, false
+	}
+	return batchResult{Value: result.value.(int64)}, false
+}
+
+// forgetBatchItem removes the dedup mark of a view failed to be incremented
+func forgetBatchItem(ctx context.Context, item batchIncrementItem, visitor string, settings *namespaceSettings) {
+	if item.By == 1 {
+		forgetHit(ctx, item.Namespace, item.Key, visitor, settings.dedupWindow())
+	}
+}
+
+func IncrementPvBatch(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "increment_pv_batch")
+
+	var request batchIncrementRequest
+	if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil || len(request.Items) == 0 || len(request.Items) > BATCH_MAX_ITEMS {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("need json body with 1 to %d items", BATCH_MAX_ITEMS))
+		return
+	}
+
+	checker := newBatchChecker(c)
+	visitor := getVisitorId(c)
+	results := make([]batchResult, len(request.Items))
+	keys := make([]string, 0)
+	bys := make([]int64, 0)
+	indexes := make([]int, 0)
+	for index := range request.Items {
+		item := &request.Items[index]
+		if item.By == 0 {
+			item.By = 1
+		}
+		if item.Namespace == "" || strings.Contains(item.Namespace, "@") || item.Key == "" {
+			results[index].Error = "need namespace without @ and key"
+			continue
+		}
+		if item.By < 0 {
+			results[index].Error = "need by as positive integer"
+			continue
+		}
+		scope := SCOPE_INCREMENT
+		if item.By != 1 {
+			scope = SCOPE_RESET
+		}
+		if errMsg := checker.check(item.Namespace, scope); errMsg != "" {
+			results[index].Error = errMsg
+			continue
+		}
+		result, ok := countBatchItem(*item, visitor, checker.settings[item.Namespace], checker.hidden(item.Namespace), c)
+		if !ok {
+			results[index] = result
+			continue
+		}
+		keys = append(keys, constructKey(item.Namespace, item.Key))
+		bys = append(bys, item.By)
+		indexes = append(indexes, index)
+	}
+
+	// items of namespaces exceeding max keys fail as a whole
+	quotas := make(map[string][]string)
+	for _, index := range indexes {
+		item := request.Items[index]
+		quotas[item.Namespace] = append(quotas[item.Namespace], constructKey(item.Namespace, item.Key))
+	}
+	exceeded := make(map[string]string)
+	for namespace, namespaceKeys := range quotas {
+		err := checker.settings[namespace].checkKeyQuota(ctx, namespace, namespaceKeys...)
+		if errors.Is(err, errTooManyKeys) {
+			exceeded[namespace] = "too many keys in this namespace"
+		} else if err != nil {
+			G_logger.WithContext(ctx).Warn(err)
+			exceeded[namespace] = "internal error"
+		}
+	}
+	namespaces := make([]string, 0)
+	allowed := 0
+	for i, index := range indexes {
+		item := request.Items[index]
+		if errMsg, ok := exceeded[item.Namespace]; ok {
+			results[index].Error = errMsg
+			forgetBatchItem(ctx, item, visitor, checker.settings[item.Namespace])
+			continue
+		}
+		keys[allowed], bys[allowed], indexes[allowed] = keys[i], bys[i], index
+		namespaces = append(namespaces, item.Namespace)
+		allowed++
+	}
+	keys, bys, indexes = keys[:allowed], bys[:allowed], indexes[:allowed]
+
+	values, err := G_db.BatchIncrBy(ctx, keys, bys)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		for _, index := range indexes {
+			item := request.Items[index]
+			forgetBatchItem(ctx, item, visitor, checker.settings[item.Namespace])
+		}
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	checker.expireKeys(ctx, namespaces, keys)
+	now := time.Now()
+	for i, index := range indexes {
+		item := request.Items[index]
+		value := values[i].value.(int64)
+		results[index] = batchResult{Value: value, Counted: true}
+		if item.By == 1 {
+			recordView(ctx, checker.settings[item.Namespace], item.Namespace, item.Key, now)
+		} else if err := resetLeaderboard(ctx, checker.settings[item.Namespace], item.Namespace, item.Key, value); err != nil {
+			G_logger.WithContext(ctx).Warn(err)
+		}
+	}
+
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "incr keys successfully",
+		Data:   make([]Data, 0),
+	}
+	failed := 0
+	for index, item := range request.Items {
+		if checker.hidden(item.Namespace) {
+			results[index] = batchResult{Error: "invalid namespace"}
+		}
+		if results[index].Error != "" {
+			failed++
+		}
+		errMsg.Data = append(errMsg.Data, Data{Key: constructKey(item.Namespace, item.Key), Value: results[index]})
+	}
+	if failed > 0 {
+		errMsg.ErrMsg = fmt.Sprintf("incr keys with %d failed", failed)
+	}
+	c.JSON(http.StatusOK, errMsg)
+}
+
+type batchGetItem struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+}
+
+type batchGetRequest struct {
+	Items []batchGetItem `json:"items"`
+}
+
+// batchGetResult is the value of every item in Data, missing keys
+// are reported by exists instead of an empty value
+type batchGetResult struct {
+	Value  int64  `json:"value"`
+	Exists bool   `json:"exists"`
+	Error  string `json:"error,omitempty"`
+}
+
+// parse items from `namespace` and repeated `key` in query, or the json body
+// of POST, where items without namespace take the one in query
+func parseBatchGetItems(c *gin.Context) ([]batchGetItem, bool) {
+	namespace := c.Query("namespace")
+	items := make([]batchGetItem, 0)
+	if c.Request.Method == http.MethodPost {
+		var request batchGetRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, fmt.Sprintf("need json body with 1 to %d items", BATCH_MAX_ITEMS))
+			return nil, false
+		}
+		items = request.Items
+	} else {
+		for _, key := range c.QueryArray("key") {
+			items = append(items, batchGetItem{Key: key})
+		}
+	}
+	if len(items) == 0 || len(items) > BATCH_MAX_ITEMS {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("need 1 to %d items", BATCH_MAX_ITEMS))
+		return nil, false
+	}
+	for index := range items {
+		if items[index].Namespace == "" {
+			items[index].Namespace = namespace
+		}
+	}
+	return items, true
+}
+
+func GetPvBatch(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "get_pv_batch")
+
+	items, ok := parseBatchGetItems(c)
+	if !ok {
+		return
+	}
+
+	checker := newBatchChecker(c)
+	results := make([]batchGetResult, len(items))
+	keys := make([]string, 0)
+	namespaces := make([]string, 0)
+	indexes := make([]int, 0)
+	for index, item := range items {
+		if item.Namespace == "" || strings.Contains(item.Namespace, "@") || item.Key == "" {
+			results[index].Error = "need namespace without @ and key"
+			continue
+		}
+		if errMsg := checker.check(item.Namespace, SCOPE_READ); errMsg != "" {
+			results[index].Error = errMsg
+			continue
+		}
+		keys = append(keys, constructKey(item.Namespace, item.Key))
+		namespaces = append(namespaces, item.Namespace)
+		indexes = append(indexes, index)
+	}
+
+	values, err := G_db.BatchGet(ctx, keys...)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	checker.expireKeys(ctx, namespaces, keys)
+	for i, index := range indexes {
+		value, _ := values[i].value.(string)
+		if value == "" {
+			continue
+		}
+		results[index].Value, _ = strconv.ParseInt(value, 10, 64)
+		results[index].Exists = true
+	}
+
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "get keys successfully",
+		Data:   make([]Data, 0),
+	}
+	for index, item := range items {
+		errMsg.Data = append(errMsg.Data, Data{Key: constructKey(item.Namespace, item.Key), Value: results[index]})
+	}
+	c.JSON(http.StatusOK, errMsg)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIncrementPvBatch(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	for _, namespace := range []string{"batchtest", "batchtest2"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/pv/create?namespace="+namespace+"&secret=world", nil)
+		router.ServeHTTP(w, req)
+	}
+	defer func() {
+		for _, pattern := range []string{"history@batchtest*", "top@batchtest*", "key@batchtest*"} {
+			keys, _ := G_db.GetPrefixMatchKeys(ctx, pattern)
+			G_db.Delete(ctx, keys...)
+		}
+		G_db.Delete(ctx, "namespace@batchtest", "settings@batchtest", "namespace@batchtest2", "settings@batchtest2",
+			"call@create_pv", "call@increment_pv_batch")
+	}()
+
+	item := func(errMsg ErrorMessage, index int) map[string]interface{} {
+		return errMsg.Data[index].Value.(map[string]interface{})
+	}
+
+	for _, body := range []string{"", "{}", `{"items": []}`, "[1, 2]"} {
+		if w, _ := MockRequest(router, "POST", "/pv/increment/batch", nil, body); w.Code != 400 {
+			t.Errorf("body %s should be invalid", body)
+		}
+	}
+	if w, _ := MockRequest(router, "POST", "/pv/increment/batch", nil, `{"items": [`+strings.Repeat(`{"namespace": "batchtest", "key": "a"},`, BATCH_MAX_ITEMS)+`{}]}`); w.Code != 400 {
+		t.Fail()
+	}
+
+	w, errMsg := MockRequest(router, "POST", "/pv/increment/batch", nil, `{"items": [
+		{"namespace": "batchtest", "key": "page"},
+		{"namespace": "batchtest", "key": "site"},
+		{"namespace": "batchtest2", "key": "tag", "by": 1},
+		{"namespace": "batchtest", "key": "page"},
+		{"namespace": "batchtest", "key": "page", "by": 10},
+		{"namespace": "notexist", "key": "page"},
+		{"namespace": "batchtest", "key": ""},
+		{"namespace": "batchtest", "key": "page", "by": -1}
+	]}`)
+	if w.Code != 200 || errMsg.Code != 0 || len(errMsg.Data) != 8 || errMsg.ErrMsg != "incr keys with 4 failed" {
+		t.FailNow()
+	}
+	if errMsg.Data[0].Key != "key@batchtest@page" || item(errMsg, 0)["value"] != float64(1) || item(errMsg, 0)["counted"] != true {
+		t.Fail()
+	}
+	if item(errMsg, 2)["value"] != float64(1) || item(errMsg, 3)["value"] != float64(2) {
+		t.Fail()
+	}
+	// by other than 1 needs authentication
+	if item(errMsg, 4)["error"] != "authentication failed" || item(errMsg, 4)["counted"] != false {
+		t.Fail()
+	}
+	if item(errMsg, 5)["error"] != "invalid namespace" || item(errMsg, 6)["error"] == nil || item(errMsg, 7)["error"] == nil {
+		t.Fail()
+	}
+
+	w, errMsg = MockRequest(router, "POST", "/pv/increment/batch?secret=world", nil, `{"items": [
+		{"namespace": "batchtest", "key": "page", "by": 10},
+		{"namespace": "batchtest2", "key": "tag", "by": 10}
+	]}`)
+	if w.Code != 200 || item(errMsg, 0)["value"] != float64(12) || item(errMsg, 1)["value"] != float64(11) {
+		t.Fail()
+	}
+
+	// nothing to increment
+	w, errMsg = MockRequest(router, "POST", "/pv/increment/batch", nil, `{"items": [{"namespace": "notexist", "key": "page"}]}`)
+	if w.Code != 200 || item(errMsg, 0)["error"] != "invalid namespace" {
+		t.Fail()
+	}
+
+	if results, err := G_db.ZTop(ctx, []string{constructTopKey("batchtest")}, 10); err != nil || len(results) != 2 || results[0].value.(int64) != 12 {
+		fmt.Println(results)
+		t.Fail()
+	}
+}
+
+func TestGetPvBatch(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	for _, namespace := range []string{"batchtest", "batchtest2"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/pv/create?namespace="+namespace, nil)
+		router.ServeHTTP(w, req)
+	}
+	_ = G_db.Set(ctx, "key@batchtest@a", 3, true)
+	_ = G_db.Set(ctx, "key@batchtest2@b", 5, true)
+	defer G_db.Delete(ctx, "namespace@batchtest", "settings@batchtest", "namespace@batchtest2", "settings@batchtest2",
+		"key@batchtest@a", "key@batchtest2@b",
+		"call@create_pv", "call@get_pv_batch")
+
+	item := func(errMsg ErrorMessage, index int) map[string]interface{} {
+		return errMsg.Data[index].Value.(map[string]interface{})
+	}
+
+	if w, _ := MockRequest(router, "GET", "/pv/get/batch?namespace=batchtest", nil, ""); w.Code != 400 {
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "POST", "/pv/get/batch", nil, "{"); w.Code != 400 {
+		t.Fail()
+	}
+
+	w, errMsg := MockRequest(router, "GET", "/pv/get/batch?namespace=batchtest&key=a&key=missing", nil, "")
+	if w.Code != 200 || len(errMsg.Data) != 2 || errMsg.Data[0].Key != "key@batchtest@a" {
+		t.FailNow()
+	}
+	if item(errMsg, 0)["value"] != float64(3) || item(errMsg, 0)["exists"] != true || item(errMsg, 1)["exists"] != false {
+		t.Fail()
+	}
+
+	w, errMsg = MockRequest(router, "POST", "/pv/get/batch?namespace=batchtest", nil, `{"items": [
+		{"key": "a"},
+		{"namespace": "batchtest2", "key": "b"},
+		{"namespace": "notexist", "key": "a"}
+	]}`)
+	if w.Code != 200 || len(errMsg.Data) != 3 {
+		t.FailNow()
+	}
+	if item(errMsg, 0)["value"] != float64(3) || item(errMsg, 1)["value"] != float64(5) || item(errMsg, 2)["error"] != "invalid namespace" {
+		t.Fail()
+	}
+
+	// nothing to get
+	w, errMsg = MockRequest(router, "POST", "/pv/get/batch", nil, `{"items": [{"namespace": "notexist", "key": "a"}]}`)
+	if w.Code != 200 || item(errMsg, 0)["error"] != "invalid namespace" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("counter")
+
+// BoltStore keeps everything in a bbolt file, every command is a transaction
+type BoltStore struct {
+	boltDB *bolt.DB
+	logger *logrus.Logger
+	fsync  bool
+	keyTTL time.Duration
+
+	syncInterval  time.Duration
+	sweepInterval time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	// for test
+	now func() time.Time
+}
+
+var _ Store = (*BoltStore)(nil)
+
+// NewBoltStore opens the bolt file at path. Without fsync, commits are
+// flushed to disk every sync interval instead of on every write,
+// which is faster but may lose the latest writes when the machine crashes.
+func NewBoltStore(path string, fsync bool, syncInterval, sweepInterval, keyTTL time.Duration, logger *logrus.Logger) *BoltStore {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		logger.WithField("component", "bolt").Info("create bolt directory failed: ", err)
+		return nil
+	}
+	boltDB, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: 1 * time.Second,
+		NoSync:  !fsync,
+	})
+	if err != nil {
+		logger.WithField("component", "bolt").Info("open bolt file failed: ", err)
+		return nil
+	}
+	err = boltDB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		logger.WithField("component", "bolt").Info("create bolt bucket failed: ", err)
+		boltDB.Close()
+		return nil
+	}
+
+	db := &BoltStore{
+		boltDB: boltDB,
+		logger: logger,
+		fsync:  fsync,
+		keyTTL: keyTTL,
+
+		syncInterval:  syncInterval,
+		sweepInterval: sweepInterval,
+
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		now:  time.Now,
+	}
+	go db.loop()
+	return db
+}
+
+func (db *BoltStore) loop() {
+	defer close(db.done)
+	sweep := time.NewTicker(db.sweepInterval)
+	defer sweep.Stop()
+	sync := time.NewTicker(db.syncInterval)
+	defer sync.Stop()
+	for {
+		select {
+		case <-sweep.C:
+			err := db.update(func(cmd *commands) error {
+				return cmd.removeExpired()
+			})
+			if err != nil {
+				db.logger.WithField("component", "bolt").Warn(err)
+			}
+		case <-sync.C:
+			if db.fsync {
+				continue
+			}
+			if err := db.boltDB.Sync(); err != nil {
+				db.logger.WithField("component", "bolt").Warn(err)
+			}
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background loop, flushes and closes the file,
+// it could be called more than once
+func (db *BoltStore) Close() error {
+	db.stopOnce.Do(func() {
+		close(db.stop)
+		<-db.done
+		if !db.fsync {
+			if err := db.boltDB.Sync(); err != nil {
+				db.logger.WithField("component", "bolt").Warn(err)
+			}
+		}
+	})
+	return db.boltDB.Close()
+}
+
+type boltKeyspace struct {
+	bucket *bolt.Bucket
+}
+
+func (ks *boltKeyspace) load(key string) (*storeEntry, error) {
+	value := ks.bucket.Get([]byte(key))
+	if value == nil {
+		return nil, nil
+	}
+	return decodeEntry(value)
+}
+
+func (ks *boltKeyspace) save(key string, entry *storeEntry) error {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(entry); err != nil {
+		return fmt.Errorf("encode key[%s] failed. err[%v]", key, err)
+	}
+	return ks.bucket.Put([]byte(key), b.Bytes())
+}
+
+func (ks *boltKeyspace) remove(key string) error {
+	// expired entries found in a read-only transaction are left to the sweep
+	if !ks.bucket.Writable() {
+		return nil
+	}
+	return ks.bucket.Delete([]byte(key))
+}
+
+func (ks *boltKeyspace) scan(prefix string, fn func(key string, entry *storeEntry) error) error {
+	c := ks.bucket.Cursor()
+	p := []byte(prefix)
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+		entry, err := decodeEntry(v)
+		if err != nil {
+			return err
+		}
+		if err = fn(string(k), entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeEntry(value []byte) (*storeEntry, error) {
+	entry := &storeEntry{}
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(entry); err != nil {
+		return nil, fmt.Errorf("decode entry failed. err[%v]", err)
+	}
+	return entry, nil
+}
+
+// update runs fn in a read-write transaction, which is rolled back on error
+func (db *BoltStore) update(fn func(cmd *commands) error) error {
+	return db.boltDB.Update(func(tx *bolt.Tx) error {
+		ks := &boltKeyspace{bucket: tx.Bucket(boltBucket)}
+		return fn(&commands{ks: ks, now: db.now(), ttl: db.keyTTL})
+	})
+}
+
+// view runs fn in a read-only transaction
+func (db *BoltStore) view(fn func(cmd *commands) error) error {
+	return db.boltDB.View(func(tx *bolt.Tx) error {
+		ks := &boltKeyspace{bucket: tx.Bucket(boltBucket)}
+		return fn(&commands{ks: ks, now: db.now(), ttl: db.keyTTL})
+	})
+}
+
+func (db *BoltStore) RefreshExpire(ctx context.Context, key string) error {
+	return db.update(func(cmd *commands) error {
+		return cmd.refreshExpire(key)
+	})
+}
+
+func (db *BoltStore) Expire(ctx context.Context, ttl time.Duration, keys ...string) error {
+	return db.update(func(cmd *commands) error {
+		return cmd.expire(ttl, keys...)
+	})
+}
+
+func (db *BoltStore) Set(ctx context.Context, key string, value interface{}, use_ttl bool) error {
+	return db.update(func(cmd *commands) error {
+		return cmd.set(key, value, use_ttl)
+	})
+}
+
+func (db *BoltStore) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (ok bool, err error) {
+	err = db.update(func(cmd *commands) error {
+		ok, err = cmd.setNX(key, value, ttl)
+		return err
+	})
+	return ok, err
+}
+
+func (db *BoltStore) Get(ctx context.Context, key string) (result *RedisResult, err error) {
+	err = db.update(func(cmd *commands) error {
+		result, err = cmd.get(key)
+		return err
+	})
+	return result, err
+}
+
+func (db *BoltStore) BatchGet(ctx context.Context, keys ...string) (results []RedisResult, err error) {
+	err = db.update(func(cmd *commands) error {
+		results, err = cmd.batchGet(keys...)
+		return err
+	})
+	return results, err
+}
+
+func (db *BoltStore) BatchPeek(ctx context.Context, keys ...string) (results []RedisResult, err error) {
+	err = db.view(func(cmd *commands) error {
+		results, err = cmd.batchPeek(keys...)
+		return err
+	})
+	return results, err
+}
+
+func (db *BoltStore) Incr(ctx context.Context, key string) (result *RedisResult, err error) {
+	err = db.update(func(cmd *commands) error {
+		result, err = cmd.incr(key)
+		return err
+	})
+	return result, err
+}
+
+func (db *BoltStore) IncrBy(ctx context.Context, key string, by int64, floorAtZero bool) (result *RedisResult, err error) {
+	err = db.update(func(cmd *commands) error {
+		result, err = cmd.incrBy(key, by, cmd.ttl, floorAtZero)
+		return err
+	})
+	return result, err
+}
+
+func (db *BoltStore) BatchIncr(ctx context.Context, keys []string, ttls []time.Duration) (results []RedisResult, err error) {
+	err = db.update(func(cmd *commands) error {
+		results, err = cmd.batchIncr(keys, ttls)
+		return err
+	})
+	return results, err
+}
+
+func (db *BoltStore) BatchIncrBy(ctx context.Context, keys []string, bys []int64) (results []RedisResult, err error) {
+	err = db.update(func(cmd *commands) error {
+		results, err = cmd.batchIncrBy(keys, bys)
+		return err
+	})
+	return results, err
+}
+
+func (db *BoltStore) Delete(ctx context.Context, keys ...string) (cnt int64, err error) {
+	err = db.update(func(cmd *commands) error {
+		cnt, err = cmd.delete(keys...)
+		return err
+	})
+	return cnt, err
+}
+
+func (db *BoltStore) GetPrefixMatchKeys(ctx context.Context, pattern string) (keys []string, err error) {
+	err = db.view(func(cmd *commands) error {
+		keys, err = cmd.keys(pattern)
+		return err
+	})
+	return keys, err
+}
+
+func (db *BoltStore) DeleteMatchKeys(ctx context.Context, pattern string) (cnt int64, err error) {
+	err = db.update(func(cmd *commands) error {
+		cnt, err = cmd.deleteMatch(pattern)
+		return err
+	})
+	return cnt, err
+}
+
+func (db *BoltStore) Rename(ctx context.Context, keys []string, newKeys []string) error {
+	return db.update(func(cmd *commands) error {
+		return cmd.rename(keys, newKeys)
+	})
+}
+
+func (db *BoltStore) PfAdd(ctx context.Context, key string, elements ...interface{}) (result *RedisResult, err error) {
+	err = db.update(func(cmd *commands) error {
+		result, err = cmd.pfAdd(key, elements...)
+		return err
+	})
+	return result, err
+}
+
+func (db *BoltStore) PfCount(ctx context.Context, key string) (result *RedisResult, err error) {
+	err = db.update(func(cmd *commands) error {
+		result, err = cmd.pfCount(key)
+		return err
+	})
+	return result, err
+}
+
+func (db *BoltStore) BatchPfCount(ctx context.Context, keys ...string) (results []RedisResult, err error) {
+	err = db.update(func(cmd *commands) error {
+		results, err = cmd.batchPfCount(keys...)
+		return err
+	})
+	return results, err
+}
+
+func (db *BoltStore) BatchZIncrBy(ctx context.Context, keys []string, ttls []time.Duration, member string, by int64) error {
+	return db.update(func(cmd *commands) error {
+		return cmd.batchZIncrBy(keys, ttls, member, by)
+	})
+}
+
+func (db *BoltStore) ZAdd(ctx context.Context, key string, member string, score int64) error {
+	return db.update(func(cmd *commands) error {
+		return cmd.zAdd(key, member, score)
+	})
+}
+
+func (db *BoltStore) ZRem(ctx context.Context, keys []string, member string) error {
+	return db.update(func(cmd *commands) error {
+		return cmd.zRem(keys, member)
+	})
+}
+
+func (db *BoltStore) ZTop(ctx context.Context, keys []string, limit int) (results []RedisResult, err error) {
+	err = db.view(func(cmd *commands) error {
+		results, err = cmd.zTop(keys, limit)
+		return err
+	})
+	return results, err
+}
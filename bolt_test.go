@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func MockNewBoltStore(t *testing.T) *BoltStore {
+	logger := MockNewLogger()
+
+	db := NewBoltStore(filepath.Join(t.TempDir(), "counter.db"), false, time.Second, time.Hour, DefaultConfig().Store.KeyTTL, logger)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestBoltGetAndSet(t *testing.T) {
+	db := MockNewBoltStore(t)
+	defer CleanLog()
+
+	ret, err := db.Get(ctx, "hello")
+	if ret != nil || err == nil {
+		t.Fail()
+	}
+	err = db.Set(ctx, "test", "yes", true)
+	if err != nil {
+		t.Fail()
+	}
+	ret, err = db.Get(ctx, "test")
+	if err != nil || ret.value != "yes" {
+		fmt.Println(err)
+		t.Fail()
+	}
+}
+
+func TestBoltSetNX(t *testing.T) {
+	db := MockNewBoltStore(t)
+	defer CleanLog()
+
+	now := time.Now()
+	db.now = func() time.Time { return now }
+	if ok, err := db.SetNX(ctx, "dedup", 1, time.Minute); !ok || err != nil {
+		t.Fail()
+	}
+	if ok, err := db.SetNX(ctx, "dedup", 2, time.Minute); ok || err != nil {
+		t.Fail()
+	}
+	now = now.Add(time.Minute)
+	if ok, err := db.SetNX(ctx, "dedup", 3, time.Minute); !ok || err != nil {
+		t.Fail()
+	}
+}
+
+func TestBoltIncr(t *testing.T) {
+	db := MockNewBoltStore(t)
+	defer CleanLog()
+
+	_ = db.Set(ctx, "counter", 0, true)
+	ret, err := db.Incr(ctx, "counter")
+	if err != nil || ret.value.(int64) != 1 {
+		fmt.Println(err)
+		t.Fail()
+	}
+
+	// failure case
+	_ = db.Set(ctx, "test", "yes", true)
+	ret, err = db.Incr(ctx, "test")
+	if ret != nil || err == nil {
+		t.Fail()
+	}
+}
+
+func TestBoltIncrBy(t *testing.T) {
+	db := MockNewBoltStore(t)
+	defer CleanLog()
+
+	ret, err := db.IncrBy(ctx, "counter", 5, false)
+	if err != nil || ret.value.(int64) != 5 {
+		fmt.Println(err)
+		t.Fail()
+	}
+	ret, err = db.IncrBy(ctx, "counter", -7, false)
+	if err != nil || ret.value.(int64) != -2 {
+		t.Fail()
+	}
+	ret, err = db.IncrBy(ctx, "counter", -1, true)
+	if err != nil || ret.value.(int64) != 0 {
+		t.Fail()
+	}
+	if ret, err := db.Get(ctx, "counter"); err != nil || ret.value != "0" {
+		t.Fail()
+	}
+}
+
+func TestBoltBatchGetAndDelete(t *testing.T) {
+	db := MockNewBoltStore(t)
+	defer CleanLog()
+
+	_ = db.Set(ctx, "a", 1, true)
+	_ = db.Set(ctx, "b", 2, true)
+	results, err := db.BatchGet(ctx, "a", "b", "c")
+	if err != nil || len(results) != 3 || results[1].value != "2" || results[2].value != "" {
+		fmt.Println(results)
+		t.Fail()
+	}
+
+	cnt, err := db.Delete(ctx, "a", "b", "c")
+	if err != nil || cnt != 2 {
+		t.Fail()
+	}
+	if ret, err := db.Get(ctx, "a"); ret != nil || err == nil {
+		t.Fail()
+	}
+}
+
+func TestBoltGetPrefixMatchKeys(t *testing.T) {
+	db := MockNewBoltStore(t)
+	defer CleanLog()
+
+	now := time.Now()
+	db.now = func() time.Time { return now }
+	for i := 0; i < 33; i++ {
+		_ = db.Set(ctx, fmt.Sprintf("key@test@%d", i), "value", true)
+	}
+	_ = db.Set(ctx, "key@other@0", "value", true)
+	keys, err := db.GetPrefixMatchKeys(ctx, "key@test@*")
+	if len(keys) != 33 || err != nil {
+		t.Fail()
+	}
+
+	// expired keys are skipped
+	now = now.Add(DefaultConfig().Store.KeyTTL)
+	keys, _ = db.GetPrefixMatchKeys(ctx, "key@*")
+	if len(keys) != 0 {
+		t.Fail()
+	}
+}
+
+func TestBoltPfAddAndCount(t *testing.T) {
+	db := MockNewBoltStore(t)
+	defer CleanLog()
+
+	var ret *RedisResult
+	for _, visitor := range []string{"a", "b", "a"} {
+		ret, _ = db.PfAdd(ctx, "hll", visitor)
+	}
+	if ret.value.(int64) != 2 {
+		t.Fail()
+	}
+	ret, err := db.PfCount(ctx, "hll")
+	if err != nil || ret.value.(int64) != 2 {
+		t.Fail()
+	}
+}
+
+func TestBoltReopen(t *testing.T) {
+	logger := MockNewLogger()
+	defer CleanLog()
+
+	path := filepath.Join(t.TempDir(), "data", "counter.db")
+	db := NewBoltStore(path, true, time.Second, time.Hour, DefaultConfig().Store.KeyTTL, logger)
+	_ = db.Set(ctx, "namespace@test", string([]byte{0xff, 0x00}), false)
+	_, _ = db.Incr(ctx, "key@test@test")
+	if err := db.Close(); err != nil {
+		t.Error(err)
+	}
+	// closed by the signal handler and others
+	if err := db.Close(); err != nil {
+		t.Error(err)
+	}
+
+	db = NewBoltStore(path, true, time.Second, time.Hour, DefaultConfig().Store.KeyTTL, logger)
+	defer db.Close()
+	ret, err := db.Get(ctx, "namespace@test")
+	if err != nil || ret.value != string([]byte{0xff, 0x00}) {
+		t.Fail()
+	}
+	ret, err = db.Incr(ctx, "key@test@test")
+	if err != nil || ret.value.(int64) != 2 {
+		t.Fail()
+	}
+}
+
+func TestBoltBatchPeek(t *testing.T) {
+	db := MockNewBoltStore(t)
+	defer CleanLog()
+
+	now := time.Now()
+	db.now = func() time.Time { return now }
+	_, _ = db.BatchIncr(ctx, []string{"hour", "day"}, []time.Duration{time.Hour, 24 * time.Hour})
+
+	// expired entry in a read-only transaction
+	now = now.Add(2 * time.Hour)
+	results, err := db.BatchPeek(ctx, "hour", "day")
+	if err != nil || results[0].value != "" || results[1].value != "1" {
+		fmt.Println(err, results)
+		t.Fail()
+	}
+}
+
+func TestBoltDeleteMatchKeysAndRename(t *testing.T) {
+	db := MockNewBoltStore(t)
+	defer CleanLog()
+
+	for i := 0; i < 3; i++ {
+		_ = db.Set(ctx, fmt.Sprintf("key@a@%d", i), i, true)
+	}
+	_ = db.Set(ctx, "key@b@0", 0, true)
+
+	if err := db.Rename(ctx, []string{"key@a@0", "key@a@1"}, []string{"key@c@0", "key@b@0"}); err == nil {
+		t.Fail()
+	}
+	if err := db.Rename(ctx, []string{"key@a@0"}, []string{"key@c@0"}); err != nil {
+		t.Fail()
+	}
+	if ret, _ := db.Get(ctx, "key@c@0"); ret == nil || ret.value != "0" {
+		t.Fail()
+	}
+
+	cnt, err := db.DeleteMatchKeys(ctx, "key@a@*")
+	if cnt != 2 || err != nil {
+		t.Fail()
+	}
+	keys, _ := db.GetPrefixMatchKeys(ctx, "key@*")
+	if len(keys) != 2 {
+		fmt.Println(keys)
+		t.Fail()
+	}
+}
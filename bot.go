@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// substrings of the user agents of crawlers, uptime checkers, scripts and
+// automated browsers, matched case-insensitively
+var defaultBotUserAgents = []string{
+	"bot", "crawl", "spider", "slurp", "archiver", "facebookexternalhit", "embedly",
+	"curl", "wget", "python-requests", "python-urllib", "go-http-client", "java/", "okhttp",
+	"libwww", "httpclient", "node-fetch", "axios",
+	"uptime", "pingdom", "statuscake", "monitor", "lighthouse", "pagespeed",
+	"headless", "phantomjs", "selenium", "puppeteer", "playwright",
+}
+
+var G_botFilter *BotFilter
+
+type BotFilter struct {
+	userAgents []string
+	networks   []*net.IPNet
+	headless   bool
+}
+
+// NewBotFilter returns nil if the filter is disabled
+func NewBotFilter(config BotFilterConfig) (*BotFilter, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	filter := &BotFilter{headless: config.Headless}
+	for _, ua := range config.UserAgents {
+		filter.userAgents = append(filter.userAgents, strings.ToLower(ua))
+	}
+	for _, cidr := range config.Cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("parse cidr[%s] failed. err[%v]", cidr, err)
+		}
+		filter.networks = append(filter.networks, network)
+	}
+	return filter, nil
+}
+
+// Check tells whether the request is from a bot, and why
+func (filter *BotFilter) Check(c *gin.Context) (bool, string) {
+	if filter == nil {
+		return false, ""
+	}
+	ua := strings.ToLower(c.Request.UserAgent())
+	if ua == "" {
+		return true, "empty user agent"
+	}
+	for _, item := range filter.userAgents {
+		if strings.Contains(ua, item) {
+			return true, "user agent " + item
+		}
+	}
+	if ip := net.ParseIP(c.ClientIP()); ip != nil {
+		for _, network := range filter.networks {
+			if network.Contains(ip) {
+				return true, "ip in " + network.String()
+			}
+		}
+	}
+	if filter.headless && isHeadless(c) {
+		return true, "headless browser"
+	}
+	return false, ""
+}
+
+// browsers always send Accept-Language, while headless browsers
+// and scripts pretending to be browsers usually don't
+func isHeadless(c *gin.Context) bool {
+	if strings.Contains(strings.ToLower(c.GetHeader("Sec-CH-UA")), "headless") {
+		return true
+	}
+	return strings.HasPrefix(c.Request.UserAgent(), "Mozilla/") && c.GetHeader("Accept-Language") == ""
+}
+
+// filtered increments of key
+func constructBotKey(namespace, key string) string {
+	return fmt.Sprintf("bot@%s@%s", namespace, key)
+}
+
+// isBotHit checks the request by the bot filter, and counts it in the
+// bot counter of key instead
+func isBotHit(namespace, key string, c *gin.Context) (bool, error) {
+	ctx := c.Request.Context()
+	isBot, reason := G_botFilter.Check(c)
+	if !isBot {
+		return false, nil
+	}
+	G_logger.WithContext(ctx).Debugf("filter bot of namespace[%s] key[%s]: %s", namespace, key, reason)
+	_, err := G_db.Incr(ctx, constructBotKey(namespace, key))
+	return true, err
+}
+
+func GetBotPv(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "bot_pv")
+
+	namespace := c.Query("namespace")
+	if ok := checkNamespace(namespace, c); !ok {
+		return
+	}
+	if ok := isNamespaceValid(namespace, c); !ok {
+		return
+	}
+	if _, ok := isReadAllowed(namespace, c.Query("key") == "", c); !ok {
+		return
+	}
+
+	keys := []string{constructBotKey(namespace, c.Query("key"))}
+	if c.Query("key") == "" {
+		var err error
+		if keys, err = G_db.GetPrefixMatchKeys(ctx, constructBotKey(escapePattern(namespace), "*")); err != nil {
+			G_logger.WithContext(ctx).Warn(err)
+			errMsg := ErrorMessage{
+				Code:   5001,
+				ErrMsg: "internal error",
+			}
+			c.JSON(http.StatusInternalServerError, errMsg)
+			return
+		}
+	}
+	results, err := G_db.BatchPeek(ctx, keys...)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "get bot hits successfully",
+		Data:   make([]Data, 0),
+	}
+	for _, item := range results {
+		// missing key means no bot hits
+		value, _ := strconv.Atoi(item.value.(string))
+		errMsg.Data = append(errMsg.Data, Data{Key: item.key, Value: value})
+	}
+	c.JSON(http.StatusOK, errMsg)
+}
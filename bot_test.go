@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const browserUserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+func TestBotFilter(t *testing.T) {
+	if filter, err := NewBotFilter(DefaultConfig().BotFilter); filter != nil || err != nil {
+		t.Fail()
+	}
+	config := DefaultConfig().BotFilter
+	config.Enabled = true
+	config.Cidrs = []string{"10.0.0.0/8"}
+	filter, err := NewBotFilter(config)
+	if filter == nil || err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(ip, ua, language string) bool {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/", nil)
+		c.Request.RemoteAddr = ip + ":1234"
+		c.Request.Header.Set("User-Agent", ua)
+		c.Request.Header.Set("Accept-Language", language)
+		isBot, reason := filter.Check(c)
+		fmt.Println(ua, isBot, reason)
+		return isBot
+	}
+	if check("192.0.2.1", browserUserAgent, "en-US") {
+		t.Fail()
+	}
+	for _, ua := range []string{
+		"",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		"Mozilla/5.0 (compatible; UptimeRobot/2.0; http://www.uptimerobot.com/)",
+		"curl/7.88.1",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36",
+	} {
+		if !check("192.0.2.1", ua, "en-US") {
+			t.Errorf("%s should be a bot", ua)
+		}
+	}
+	// by ip range
+	if !check("10.1.2.3", browserUserAgent, "en-US") {
+		t.Fail()
+	}
+	// headless heuristic
+	if !check("192.0.2.1", browserUserAgent, "") {
+		t.Fail()
+	}
+	config.Headless = false
+	filter, _ = NewBotFilter(config)
+	if check("192.0.2.1", browserUserAgent, "") {
+		t.Fail()
+	}
+
+	config.Cidrs = []string{"10.0.0.0"}
+	if _, err := NewBotFilter(config); err == nil {
+		t.Fail()
+	}
+}
+
+func TestIncrementPvByBot(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	config := DefaultConfig().BotFilter
+	config.Enabled = true
+	G_botFilter, _ = NewBotFilter(config)
+	defer func() { G_botFilter = nil }()
+
+	MockRequest(router, "POST", "/pv/create?namespace=bottest", nil, "")
+	defer func() {
+		for _, pattern := range []string{"history@bottest@*", "top@bottest*", "key@bottest@*", "bot@bottest@*", "uv@bottest@*"} {
+			keys, _ := G_db.GetPrefixMatchKeys(ctx, pattern)
+			G_db.Delete(ctx, keys...)
+		}
+		G_db.Delete(ctx, "namespace@bottest", "settings@bottest", "call@create_pv", "call@increment_pv", "call@increment_uv", "call@bot_pv")
+	}()
+
+	browser := map[string]string{"User-Agent": browserUserAgent, "Accept-Language": "en-US"}
+	crawler := map[string]string{"User-Agent": "Googlebot/2.1", "Accept-Language": "en-US"}
+
+	w, errMsg := MockRequest(router, "POST", "/pv/increment?namespace=bottest&key=page", browser, "")
+	if w.Code != 200 || errMsg.Data[0].Value != float64(1) || errMsg.Data[1].Value != true {
+		t.Fail()
+	}
+	w, errMsg = MockRequest(router, "POST", "/pv/increment?namespace=bottest&key=page", crawler, "")
+	if w.Code != 200 || errMsg.Data[0].Value != float64(1) || errMsg.Data[1].Value != false {
+		t.Fail()
+	}
+	w, errMsg = MockRequest(router, "POST", "/uv/increment?namespace=bottest&key=page", crawler, "")
+	if w.Code != 200 || errMsg.Data[0].Value != float64(0) {
+		t.Fail()
+	}
+
+	w, errMsg = MockRequest(router, "GET", "/pv/bot?namespace=bottest&key=page", nil, "")
+	if w.Code != 200 || len(errMsg.Data) != 1 || errMsg.Data[0].Key != "bot@bottest@page" || errMsg.Data[0].Value != float64(2) {
+		t.Fail()
+	}
+	w, errMsg = MockRequest(router, "GET", "/pv/bot?namespace=bottest", nil, "")
+	if w.Code != 200 || len(errMsg.Data) != 1 {
+		t.Fail()
+	}
+}
@@ -1,15 +1,504 @@
 package main
 
-import "time"
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
 
 const (
-	SERVER_PORT = ":80"
+	// retention of the time-bucketed history
+	HISTORY_HOUR_TTL   = 7 * 24 * 60 * 60 * time.Second
+	HISTORY_DAY_TTL    = 3 * 30 * 24 * 60 * 60 * time.Second
+	HISTORY_MONTH_TTL  = 3 * 365 * 24 * 60 * 60 * time.Second
+	HISTORY_MAX_POINTS = 1000
+
+	LEADERBOARD_DEFAULT_LIMIT   = 10
+	LEADERBOARD_MAX_LIMIT       = 100
+	LEADERBOARD_MAX_WINDOW_DAYS = 31
+
+	BADGE_DEFAULT_LABEL = "views"
+	BADGE_DEFAULT_COLOR = "blue"
+	// seconds to cache the read-only badge
+	BADGE_CACHE_MAX_AGE = 60
+
+	// argon2id params of namespace secrets, see RFC 9106 and OWASP
+	SECRET_ARGON2_TIME    = 2
+	SECRET_ARGON2_MEMORY  = 19 * 1024
+	SECRET_ARGON2_THREADS = 1
+	SECRET_SALT_LENGTH    = 16
+	SECRET_KEY_LENGTH     = 32
+	// bytes of the secret generated on rotation
+	SECRET_RANDOM_LENGTH = 16
+
+	// bytes of the public id and the random part of api tokens
+	TOKEN_ID_LENGTH         = 8
+	TOKEN_RANDOM_LENGTH     = 32
+	TOKEN_MAX_PER_NAMESPACE = 100
 
-	LOG_FILE_PATH = "log"
-	LOG_FILE_NAME = "counter-service.log"
+	DEDUP_MAX_WINDOW = 24 * time.Hour
 
-	DEFAULT_REDIS_URL = "redis://:@localhost:6379/0"
-	REDIS_KEY_TTL     = 3 * 30 * 24 * 60 * 60 * time.Second
-	// for test
-	// REDIS_KEY_TTL = 5 * 60 * time.Second
+	// of owner and description in namespace settings
+	SETTINGS_MAX_TEXT_LENGTH = 1024
+	SETTINGS_MAX_ORIGINS     = 100
+
+	BATCH_MAX_ITEMS = 100
+
+	// bytes of generated request ids, and the longest one taken from clients
+	REQUEST_ID_LENGTH     = 16
+	REQUEST_ID_MAX_LENGTH = 128
+
+	// keys deleted in one round when deleting a namespace
+	STORE_DELETE_BATCH = 100
 )
+
+type ServerConfig struct {
+	Port string `yaml:"port"`
+	// debug, release or test
+	Mode string `yaml:"mode"`
+}
+
+type LogConfig struct {
+	Path string `yaml:"path"`
+	Name string `yaml:"name"`
+	// trace, debug, info, warn, error, fatal or panic
+	Level string `yaml:"level"`
+	// text or json
+	Format string `yaml:"format"`
+	// builtin or external, the latter leaves rotation to tools like
+	// logrotate, which should send SIGHUP after moving the file
+	Rotation     string        `yaml:"rotation"`
+	RotationTime time.Duration `yaml:"rotation_time"`
+	// bytes, 0 means no limit
+	RotationSize int64         `yaml:"rotation_size"`
+	MaxAge       time.Duration `yaml:"max_age"`
+	// gzip rotated files
+	Compress bool `yaml:"compress"`
+}
+
+type AccessLogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// file in log.path, rotated like the log
+	Name string `yaml:"name"`
+	// combined or json
+	Format string `yaml:"format"`
+	// routes like /pv/increment logged at sample_rate, failed requests are always logged
+	SampledRoutes []string `yaml:"sampled_routes"`
+	SampleRate    float64  `yaml:"sample_rate"`
+}
+
+type StoreConfig struct {
+	// redis, memory or bolt
+	Backend string        `yaml:"backend"`
+	KeyTTL  time.Duration `yaml:"key_ttl"`
+
+	RedisUrl string `yaml:"redis_url"`
+
+	MemorySnapshotPath     string        `yaml:"memory_snapshot_path"`
+	MemorySnapshotInterval time.Duration `yaml:"memory_snapshot_interval"`
+
+	BoltFilePath string `yaml:"bolt_file_path"`
+	// fsync on every commit, otherwise sync every bolt_sync_interval
+	BoltFsync         bool          `yaml:"bolt_fsync"`
+	BoltSyncInterval  time.Duration `yaml:"bolt_sync_interval"`
+	BoltSweepInterval time.Duration `yaml:"bolt_sweep_interval"`
+}
+
+// RateLimitRule allows limit requests in every window, 0 means no limit
+type RateLimitRule struct {
+	Limit  int64         `yaml:"limit"`
+	Window time.Duration `yaml:"window"`
+}
+
+type RateLimitConfig struct {
+	// local or store, the latter counts in the store backend
+	// so the limits hold across replicas sharing a redis
+	Backend      string        `yaml:"backend"`
+	PerIp        RateLimitRule `yaml:"per_ip"`
+	PerNamespace RateLimitRule `yaml:"per_namespace"`
+	// per namespace, key and visitor, for increments only
+	PerVisitor RateLimitRule `yaml:"per_visitor"`
+}
+
+type TracingConfig struct {
+	// none, otlp, stdout or file
+	Exporter string `yaml:"exporter"`
+	// host:port of the otlp http receiver, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318 if empty
+	OtlpEndpoint string `yaml:"otlp_endpoint"`
+	OtlpInsecure bool   `yaml:"otlp_insecure"`
+	// spans are appended as json for exporter file
+	FilePath    string `yaml:"file_path"`
+	ServiceName string `yaml:"service_name"`
+	// ratio of traces started here to sample, traces from upstream keep their decision
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+	// scrapes need `Authorization: Bearer <token>` if not empty
+	Token string `yaml:"token"`
+	// namespaces labeled in the increments, others are counted as _other
+	Namespaces []string `yaml:"namespaces"`
+}
+
+type BotFilterConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// case-insensitive substrings of the user agent, an empty user agent is a bot too
+	UserAgents []string `yaml:"user_agents"`
+	// ip ranges like 66.249.64.0/19
+	Cidrs []string `yaml:"cidrs"`
+	// treat browsers looking headless as bots
+	Headless bool `yaml:"headless"`
+}
+
+// Config is loaded from defaults, the config file, environment variables
+// and command line flags, the latter overrides the former
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Log       LogConfig       `yaml:"log"`
+	AccessLog AccessLogConfig `yaml:"access_log"`
+	Store     StoreConfig     `yaml:"store"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	BotFilter BotFilterConfig `yaml:"bot_filter"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+}
+
+var G_config = DefaultConfig()
+
+func DefaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port: ":80",
+			Mode: gin.Mode(),
+		},
+		Log: LogConfig{
+			Path:   "log",
+			Name:   "counter-service.log",
+			Level:  "debug",
+			Format: LOG_FORMAT_TEXT,
+
+			Rotation:     LOG_ROTATION_BUILTIN,
+			RotationTime: 24 * time.Hour,
+			RotationSize: 0,
+			MaxAge:       7 * 24 * time.Hour,
+			Compress:     false,
+		},
+		AccessLog: AccessLogConfig{
+			Enabled:       true,
+			Name:          "access.log",
+			Format:        ACCESS_LOG_COMBINED,
+			SampledRoutes: []string{},
+			SampleRate:    1,
+		},
+		Store: StoreConfig{
+			Backend: STORE_REDIS,
+			KeyTTL:  3 * 30 * 24 * 60 * 60 * time.Second,
+
+			RedisUrl: "redis://:@localhost:6379/0",
+
+			MemorySnapshotPath:     "data/counter.snapshot",
+			MemorySnapshotInterval: 60 * time.Second,
+
+			BoltFilePath:      "data/counter.db",
+			BoltFsync:         true,
+			BoltSyncInterval:  1 * time.Second,
+			BoltSweepInterval: 1 * time.Hour,
+		},
+		RateLimit: RateLimitConfig{
+			Backend:      RATE_LIMIT_LOCAL,
+			PerIp:        RateLimitRule{Limit: 100, Window: 1 * time.Second},
+			PerNamespace: RateLimitRule{Limit: 1000, Window: 1 * time.Second},
+			PerVisitor:   RateLimitRule{Limit: 10, Window: 1 * time.Minute},
+		},
+		BotFilter: BotFilterConfig{
+			Enabled:    false,
+			UserAgents: defaultBotUserAgents,
+			Cidrs:      []string{},
+			Headless:   true,
+		},
+		Metrics: MetricsConfig{
+			Enabled:    false,
+			Path:       "/metrics",
+			Token:      "",
+			Namespaces: []string{},
+		},
+		Tracing: TracingConfig{
+			Exporter:     TRACING_NONE,
+			OtlpEndpoint: "",
+			OtlpInsecure: false,
+			FilePath:     "log/traces.json",
+			ServiceName:  "counter-service",
+			SampleRatio:  1,
+		},
+	}
+}
+
+// configField is a leaf of Config, like `store.redis_url`
+type configField struct {
+	name  string
+	value reflect.Value
+}
+
+// env name of field, like `COUNTER_STORE_REDIS_URL`
+func (f configField) env() string {
+	return "COUNTER_" + strings.ToUpper(strings.ReplaceAll(f.name, ".", "_"))
+}
+
+func (f configField) String() string {
+	if !f.value.IsValid() {
+		return ""
+	}
+	if v, ok := f.value.Interface().([]string); ok {
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprint(f.value.Interface())
+}
+
+func (f configField) Set(s string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(s)
+	case bool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(v)
+	case []string:
+		// comma separated
+		v := make([]string, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				v = append(v, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(v))
+	case time.Duration:
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(v))
+	case int, int64:
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(v)
+	case float64:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		f.value.SetFloat(v)
+	default:
+		return fmt.Errorf("unsupported type of %s", f.name)
+	}
+	return nil
+}
+
+func (config *Config) fields() []configField {
+	fields := make([]configField, 0)
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			name := prefix + strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			if v.Field(i).Kind() == reflect.Struct {
+				walk(name+".", v.Field(i))
+				continue
+			}
+			fields = append(fields, configField{name: name, value: v.Field(i)})
+		}
+	}
+	walk("", reflect.ValueOf(config).Elem())
+	return fields
+}
+
+// LoadConfig loads config by `-config` flag or COUNTER_CONFIG,
+// then applies environment variables like COUNTER_SERVER_PORT
+// and flags like `-server.port`
+func LoadConfig(args []string) (*Config, error) {
+	config := DefaultConfig()
+
+	flags := flag.NewFlagSet("counter-service", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("COUNTER_CONFIG"), "path of the yaml config file (env COUNTER_CONFIG)")
+	for _, f := range config.fields() {
+		flags.Var(f, f.name, "override "+f.name+" (env "+f.env()+")")
+	}
+	// parse once to find the config file, values are applied later
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		content, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("read config file[%s] failed. err[%v]", *configFile, err)
+		}
+		if err = yaml.UnmarshalStrict(content, config); err != nil {
+			return nil, fmt.Errorf("parse config file[%s] failed. err[%v]", *configFile, err)
+		}
+	}
+
+	for _, f := range config.fields() {
+		if value, ok := os.LookupEnv(f.env()); ok {
+			if err := f.Set(value); err != nil {
+				return nil, fmt.Errorf("invalid %s[%s]. err[%v]", f.env(), value, err)
+			}
+		}
+	}
+
+	// flags override everything
+	flags = flag.NewFlagSet("counter-service", flag.ContinueOnError)
+	flags.String("config", "", "")
+	for _, f := range config.fields() {
+		flags.Var(f, f.name, "")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (config *Config) Validate() error {
+	if _, port, err := net.SplitHostPort(config.Server.Port); err != nil || port == "" {
+		return fmt.Errorf("invalid server.port[%s], need host:port or :port", config.Server.Port)
+	}
+	switch config.Server.Mode {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+	default:
+		return fmt.Errorf("invalid server.mode[%s], need debug, release or test", config.Server.Mode)
+	}
+	if config.Log.Path == "" || config.Log.Name == "" {
+		return fmt.Errorf("need log.path and log.name")
+	}
+	if _, err := logrus.ParseLevel(config.Log.Level); err != nil {
+		return fmt.Errorf("invalid log.level[%s], need trace, debug, info, warn, error, fatal or panic", config.Log.Level)
+	}
+	if config.Log.Format != LOG_FORMAT_TEXT && config.Log.Format != LOG_FORMAT_JSON {
+		return fmt.Errorf("invalid log.format[%s], need text or json", config.Log.Format)
+	}
+	switch config.Log.Rotation {
+	case LOG_ROTATION_BUILTIN:
+		// files are named by the minute
+		if config.Log.RotationTime < time.Minute || config.Log.RotationSize < 0 || config.Log.MaxAge <= 0 {
+			return fmt.Errorf("need log.rotation_time >= 1m, log.rotation_size >= 0 and positive log.max_age")
+		}
+	case LOG_ROTATION_EXTERNAL:
+	default:
+		return fmt.Errorf("invalid log.rotation[%s], need builtin or external", config.Log.Rotation)
+	}
+
+	accessLog := config.AccessLog
+	if accessLog.Enabled && (accessLog.Name == "" || accessLog.Name == config.Log.Name) {
+		return fmt.Errorf("need access_log.name other than log.name")
+	}
+	if accessLog.Format != ACCESS_LOG_COMBINED && accessLog.Format != ACCESS_LOG_JSON {
+		return fmt.Errorf("invalid access_log.format[%s], need combined or json", accessLog.Format)
+	}
+	if accessLog.SampleRate < 0 || accessLog.SampleRate > 1 {
+		return fmt.Errorf("invalid access_log.sample_rate[%v], need between 0 and 1", accessLog.SampleRate)
+	}
+
+	store := config.Store
+	switch store.Backend {
+	case STORE_REDIS:
+		if _, err := redis.ParseURL(store.RedisUrl); err != nil {
+			return fmt.Errorf("invalid store.redis_url[%s]", store.RedisUrl)
+		}
+	case STORE_MEMORY:
+		if store.MemorySnapshotInterval < 0 {
+			return fmt.Errorf("invalid store.memory_snapshot_interval[%v]", store.MemorySnapshotInterval)
+		}
+	case STORE_BOLT:
+		if store.BoltFilePath == "" || store.BoltSyncInterval <= 0 || store.BoltSweepInterval <= 0 {
+			return fmt.Errorf("need store.bolt_file_path, positive store.bolt_sync_interval and store.bolt_sweep_interval")
+		}
+	default:
+		return fmt.Errorf("invalid store.backend[%s], need redis, memory or bolt", store.Backend)
+	}
+	if store.KeyTTL <= 0 {
+		return fmt.Errorf("invalid store.key_ttl[%v], need positive duration", store.KeyTTL)
+	}
+
+	rateLimit := config.RateLimit
+	if rateLimit.Backend != RATE_LIMIT_LOCAL && rateLimit.Backend != RATE_LIMIT_STORE {
+		return fmt.Errorf("invalid rate_limit.backend[%s], need local or store", rateLimit.Backend)
+	}
+	for name, rule := range map[string]RateLimitRule{
+		"per_ip":        rateLimit.PerIp,
+		"per_namespace": rateLimit.PerNamespace,
+		"per_visitor":   rateLimit.PerVisitor,
+	} {
+		if rule.Limit < 0 || (rule.Limit > 0 && rule.Window < time.Second) {
+			return fmt.Errorf("invalid rate_limit.%s, need limit >= 0 and window >= 1s", name)
+		}
+	}
+
+	for _, cidr := range config.BotFilter.Cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid bot_filter.cidrs[%s]", cidr)
+		}
+	}
+
+	if config.Metrics.Enabled && !strings.HasPrefix(config.Metrics.Path, "/") {
+		return fmt.Errorf("invalid metrics.path[%s], need a path like /metrics", config.Metrics.Path)
+	}
+	for _, namespace := range config.Metrics.Namespaces {
+		if namespace == "" || strings.Contains(namespace, "@") {
+			return fmt.Errorf("invalid metrics.namespaces[%s], need namespace without @", namespace)
+		}
+	}
+
+	tracing := config.Tracing
+	switch tracing.Exporter {
+	case TRACING_NONE, TRACING_OTLP, TRACING_STDOUT:
+	case TRACING_FILE:
+		if tracing.FilePath == "" {
+			return fmt.Errorf("need tracing.file_path for exporter file")
+		}
+	default:
+		return fmt.Errorf("invalid tracing.exporter[%s], need none, otlp, stdout or file", tracing.Exporter)
+	}
+	if tracing.SampleRatio < 0 || tracing.SampleRatio > 1 {
+		return fmt.Errorf("invalid tracing.sample_ratio[%v], need between 0 and 1", tracing.SampleRatio)
+	}
+	return nil
+}
+
+// String prints the config as yaml, with the redis password and the token
+// of metrics masked
+func (config *Config) String() string {
+	masked := *config
+	if u, err := url.Parse(masked.Store.RedisUrl); err == nil && u.User != nil {
+		if password, _ := u.User.Password(); password != "" {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			masked.Store.RedisUrl = u.String()
+		}
+	}
+	if masked.Metrics.Token != "" {
+		masked.Metrics.Token = "xxxxx"
+	}
+	content, err := yaml.Marshal(&masked)
+	if err != nil {
+		return err.Error()
+	}
+	return string(content)
+}
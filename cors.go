@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestOrigin is the Origin header, or the origin of the Referer header
+// which is sent by images like badges
+func requestOrigin(c *gin.Context) string {
+	if origin := c.GetHeader("Origin"); origin != "" {
+		return origin
+	}
+	if u, err := url.Parse(c.Request.Referer()); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return ""
+}
+
+// allowsOrigin tells whether origin may embed the namespace. Everyone is
+// allowed without allowed origins, so are requests without origin like
+// those outside browsers
+func (settings *namespaceSettings) allowsOrigin(origin string) bool {
+	if len(settings.AllowedOrigins) == 0 || origin == "" {
+		return true
+	}
+	normalized, ok := normalizeOrigin(origin)
+	if !ok {
+		return false
+	}
+	for _, allowed := range settings.AllowedOrigins {
+		if allowed == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// corsOrigin returns the value of Access-Control-Allow-Origin, which is `*`
+// unless the namespace in query has allowed origins, then the matching
+// origin is echoed and others get nothing
+func corsOrigin(c *gin.Context) (origin string, vary bool) {
+	ctx := c.Request.Context()
+	namespace := c.Query("namespace")
+	if namespace == "" || G_db == nil {
+		return "*", false
+	}
+	settings, err := getSettings(ctx, namespace)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		return "", true
+	}
+	if len(settings.AllowedOrigins) == 0 {
+		return "*", false
+	}
+	origin = c.GetHeader("Origin")
+	if origin == "" || !settings.allowsOrigin(origin) {
+		return "", true
+	}
+	return origin, true
+}
+
+func CORSMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		origin, vary := corsOrigin(c)
+		if origin != "" {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		// responses differ by origin, caches must not share them
+		if vary {
+			header.Add("Vary", "Origin")
+		}
+		header.Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		header.Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+		// so pages could back off by the rate limit and report the request id
+		header.Set("Access-Control-Expose-Headers", "Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, X-Request-ID")
+
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
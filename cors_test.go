@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAllowsOrigin(t *testing.T) {
+	settings := defaultSettings()
+	if !settings.allowsOrigin("https://evil.com") {
+		t.Fail()
+	}
+	settings.AllowedOrigins = []string{"https://example.com"}
+	for origin, allowed := range map[string]bool{
+		"":                     true,
+		"https://example.com":  true,
+		"HTTPS://EXAMPLE.COM":  true,
+		"http://example.com":   false,
+		"https://evil.com":     false,
+		"null":                 false,
+		"https://example.com.": false,
+	} {
+		if settings.allowsOrigin(origin) != allowed {
+			t.Errorf("origin %s should be allowed %v", origin, allowed)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	router := gin.Default()
+	logger := MockNewLogger()
+	router.Use(CORSMiddleware())
+	AddRouters(router, MockNewRedisClient(), logger)
+	defer CleanLog()
+
+	defer func() {
+		for _, pattern := range namespaceKeyPatterns("corstest") {
+			G_db.DeleteMatchKeys(ctx, pattern)
+		}
+		G_db.Delete(ctx, "call@create_pv", "call@update_settings", "call@increment_pv", "call@increment_uv", "call@badge_pv")
+	}()
+
+	allowed := map[string]string{"Origin": "https://example.com"}
+	disallowed := map[string]string{"Origin": "https://evil.com"}
+
+	MockRequest(router, "POST", "/pv/create?namespace=corstest&secret=world", nil, "")
+
+	// wildcard without allowed origins
+	w, _ := MockRequest(router, "OPTIONS", "/pv/increment?namespace=corstest&key=page", disallowed, "")
+	if w.Code != 204 || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fail()
+	}
+	MockRequest(router, "PUT", "/pv/namespace?namespace=corstest&secret=world", nil, `{"allowed_origins":["https://example.com"]}`)
+
+	// preflight
+	w, _ = MockRequest(router, "OPTIONS", "/pv/increment?namespace=corstest&key=page", allowed, "")
+	if w.Code != 204 || w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" ||
+		w.Header().Get("Vary") != "Origin" || w.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Fail()
+	}
+	w, _ = MockRequest(router, "OPTIONS", "/pv/increment?namespace=corstest&key=page", disallowed, "")
+	if w.Code != 204 || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fail()
+	}
+	w, _ = MockRequest(router, "OPTIONS", "/pv/increment/batch", disallowed, "")
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fail()
+	}
+
+	// increments from disallowed origins are not counted
+	increment := func(url string, headers map[string]string, value int, counted bool) {
+		w, errMsg := MockRequest(router, "POST", url, headers, "")
+		if w.Code != 200 || len(errMsg.Data) < 1 || errMsg.Data[0].Value != float64(value) ||
+			(len(errMsg.Data) == 2 && errMsg.Data[1].Value != counted) {
+			t.Errorf("increment %s with %v should be %d", url, headers, value)
+		}
+	}
+	increment("/pv/increment?namespace=corstest&key=page", allowed, 1, true)
+	increment("/pv/increment?namespace=corstest&key=page", disallowed, 1, false)
+	increment("/pv/increment?namespace=corstest&key=page", map[string]string{"Referer": "https://example.com/post/1"}, 2, true)
+	increment("/pv/increment?namespace=corstest&key=page", map[string]string{"Referer": "https://evil.com/post/1"}, 2, false)
+	// not from browsers
+	increment("/pv/increment?namespace=corstest&key=page", nil, 3, true)
+	increment("/uv/increment?namespace=corstest&key=page", disallowed, 0, false)
+	increment("/uv/increment?namespace=corstest&key=page", allowed, 1, true)
+
+	w, _ = MockRequest(router, "GET", "/badge/pv.svg?namespace=corstest&key=page", map[string]string{"Referer": "https://evil.com/"}, "")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), ">3</text>") {
+		t.Fail()
+	}
+	w, _ = MockRequest(router, "GET", "/badge/pv.svg?namespace=corstest&key=page", map[string]string{"Referer": "https://example.com/"}, "")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), ">4</text>") {
+		t.Fail()
+	}
+}
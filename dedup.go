@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// legacy dedup window of namespace in seconds, moved into settings
+func constructDedupPolicyKey(namespace string) string {
+	return fmt.Sprintf("dedup@%s", namespace)
+}
+
+// marks visitor has been counted for key, expires with the window
+func constructDedupKey(namespace, key, visitor string) string {
+	return fmt.Sprintf("dedup@%s@%s@%s", namespace, key, visitor)
+}
+
+// isDuplicateHit tells whether visitor has hit key in the dedup window,
+// the first hit in the window starts it
+func isDuplicateHit(ctx context.Context, namespace, key, visitor string, window time.Duration) (bool, error) {
+	if window <= 0 {
+		return false, nil
+	}
+	first, err := G_db.SetNX(ctx, constructDedupKey(namespace, key, visitor), 1, window)
+	if err != nil {
+		return false, err
+	}
+	return !first, nil
+}
+
+// forgetHit removes the mark of a hit which failed to be counted, so the
+// retry of visitor is counted
+func forgetHit(ctx context.Context, namespace, key, visitor string, window time.Duration) {
+	if window <= 0 {
+		return
+	}
+	if _, err := G_db.Delete(ctx, constructDedupKey(namespace, key, visitor)); err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+	}
+}
+
+func SetPvDedup(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "dedup_pv")
+
+	namespace := c.Query("namespace")
+	if ok := checkNamespace(namespace, c); !ok {
+		return
+	}
+	window, err := time.ParseDuration(c.Query("window"))
+	if err != nil || window < 0 || window > DEDUP_MAX_WINDOW || window%time.Second != 0 {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("need window in seconds like 30m, between 0 (disable) and %v", DEDUP_MAX_WINDOW))
+		return
+	}
+	if ok := isAuthorized(namespace, SCOPE_ADMIN, c); !ok {
+		return
+	}
+
+	settings, err := getSettings(ctx, namespace)
+	if err == nil {
+		settings.DedupWindow = int64(window / time.Second)
+		err = saveSettings(ctx, namespace, settings)
+	}
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "set dedup window successfully",
+	}
+	errMsg.Data = append(errMsg.Data, Data{Key: "window", Value: window.String()})
+	c.JSON(http.StatusOK, errMsg)
+}
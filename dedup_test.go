@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDedupPv(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/pv/create?namespace=deduptest&secret=world", nil)
+	router.ServeHTTP(w, req)
+	defer func() {
+		for _, pattern := range []string{"history@deduptest@*", "top@deduptest*", "key@deduptest@*", "dedup@deduptest*"} {
+			keys, _ := G_db.GetPrefixMatchKeys(ctx, pattern)
+			G_db.Delete(ctx, keys...)
+		}
+		G_db.Delete(ctx, "namespace@deduptest", "settings@deduptest", "call@create_pv", "call@dedup_pv", "call@increment_pv", "call@update_settings",
+			"call@increment_pv_batch")
+	}()
+
+	increment := func(query string, value int, counted bool) {
+		w, errMsg := MockRequest(router, "POST", "/pv/increment?namespace=deduptest&key=page"+query, nil, "")
+		if w.Code != 200 || len(errMsg.Data) != 2 || errMsg.Data[0].Value != float64(value) || errMsg.Data[1].Value != counted {
+			t.Errorf("increment%s should be %d and counted %v", query, value, counted)
+		}
+	}
+
+	if w, _ := MockRequest(router, "POST", "/pv/dedup?namespace=deduptest&secret=world&window=48h", nil, ""); w.Code != 400 {
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "POST", "/pv/dedup?namespace=deduptest&secret=world&window=1.5s", nil, ""); w.Code != 400 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/dedup?namespace=deduptest&secret=hello&window=30m", nil, ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+
+	// no dedup by default
+	increment("", 1, true)
+	increment("", 2, true)
+
+	if w, _ := MockRequest(router, "POST", "/pv/dedup?namespace=deduptest&secret=world&window=30m", nil, ""); w.Code != 200 {
+		t.Fail()
+	}
+	increment("", 3, true)
+	increment("", 3, false)
+	increment("&visitor=alice", 4, true)
+	increment("&visitor=alice", 4, false)
+
+	// the window expires
+	mockRedis.FastForward(30 * time.Minute)
+	increment("", 5, true)
+
+	// a hit failed to be counted isn't a duplicate when retried
+	MockRequest(router, "PUT", "/pv/namespace?namespace=deduptest&secret=world", nil, `{"max_keys":1}`)
+	if w, _ := MockRequest(router, "POST", "/pv/increment?namespace=deduptest&key=other&visitor=bob", nil, ""); w.Code == 200 {
+		t.Fail()
+	}
+	body := `{"items": [{"namespace": "deduptest", "key": "batch"}]}`
+	if _, errMsg := MockRequest(router, "POST", "/pv/increment/batch?visitor=bob", nil, body); errMsg.Data[0].Value.(map[string]interface{})["error"] == nil {
+		t.Fail()
+	}
+	MockRequest(router, "PUT", "/pv/namespace?namespace=deduptest&secret=world", nil, `{"max_keys":0}`)
+	if w, errMsg := MockRequest(router, "POST", "/pv/increment?namespace=deduptest&key=other&visitor=bob", nil, ""); w.Code != 200 || errMsg.Data[1].Value != true {
+		t.Fail()
+	}
+	if _, errMsg := MockRequest(router, "POST", "/pv/increment/batch?visitor=bob", nil, body); errMsg.Data[0].Value.(map[string]interface{})["counted"] != true {
+		t.Fail()
+	}
+
+	if w, _ := MockRequest(router, "POST", "/pv/dedup?namespace=deduptest&secret=world&window=0", nil, ""); w.Code != 200 {
+		t.Fail()
+	}
+	increment("", 6, true)
+	increment("", 7, true)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type granularity struct {
+	// layout of the bucket, also used to parse `from` and `to`
+	layout        string
+	ttl           time.Duration
+	defaultPoints int
+	truncate      func(t time.Time) time.Time
+	next          func(t time.Time) time.Time
+	prev          func(t time.Time) time.Time
+}
+
+var granularities = map[string]granularity{
+	"hour": {
+		layout:        "2006-01-02T15",
+		ttl:           HISTORY_HOUR_TTL,
+		defaultPoints: 24,
+		truncate:      func(t time.Time) time.Time { return t.Truncate(time.Hour) },
+		next:          func(t time.Time) time.Time { return t.Add(time.Hour) },
+		prev:          func(t time.Time) time.Time { return t.Add(-time.Hour) },
+	},
+	"day": {
+		layout:        "2006-01-02",
+		ttl:           HISTORY_DAY_TTL,
+		defaultPoints: 30,
+		truncate: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		},
+		next: func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+		prev: func(t time.Time) time.Time { return t.AddDate(0, 0, -1) },
+	},
+	"month": {
+		layout:        "2006-01",
+		ttl:           HISTORY_MONTH_TTL,
+		defaultPoints: 12,
+		truncate: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		},
+		next: func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+		prev: func(t time.Time) time.Time { return t.AddDate(0, -1, 0) },
+	},
+}
+
+func constructHistoryKey(namespace, key, granularity, bucket string) string {
+	return fmt.Sprintf("history@%s@%s@%s@%s", namespace, granularity, bucket, key)
+}
+
+// recordHistory increments the hourly, daily and monthly buckets of key
+func recordHistory(ctx context.Context, namespace, key string, now time.Time) error {
+	now = now.UTC()
+	keys := make([]string, 0)
+	ttls := make([]time.Duration, 0)
+	for name, g := range granularities {
+		bucket := now.Format(g.layout)
+		keys = append(keys, constructHistoryKey(namespace, key, name, bucket))
+		ttls = append(ttls, g.ttl)
+	}
+	_, err := G_db.BatchIncr(ctx, keys, ttls)
+	return err
+}
+
+// parse the time range of history, default to the latest points
+func parseHistoryRange(g granularity, from, to string, now time.Time) (time.Time, time.Time, error) {
+	end := g.truncate(now.UTC())
+	if to != "" {
+		t, err := time.ParseInLocation(g.layout, to, time.UTC)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		end = t
+	}
+	if from != "" {
+		start, err := time.ParseInLocation(g.layout, from, time.UTC)
+		return start, end, err
+	}
+	start := end
+	for i := 1; i < g.defaultPoints; i++ {
+		start = g.prev(start)
+	}
+	return start, end, nil
+}
+
+func GetPvHistory(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "history_pv")
+
+	namespace := c.Query("namespace")
+	key := c.Query("key")
+	if ok := checkNamespaceAndKey(namespace, key, c); !ok {
+		return
+	}
+	name := c.DefaultQuery("granularity", "day")
+	g, ok := granularities[name]
+	if !ok {
+		c.JSON(http.StatusBadRequest, "need granularity of hour, day or month")
+		return
+	}
+	start, end, err := parseHistoryRange(g, c.Query("from"), c.Query("to"), time.Now())
+	if err != nil || start.After(end) {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("need from and to like %s, and from is not after to", g.layout))
+		return
+	}
+	if ok := isNamespaceValid(namespace, c); !ok {
+		return
+	}
+	if _, ok := isReadAllowed(namespace, false, c); !ok {
+		return
+	}
+
+	buckets := make([]string, 0)
+	keys := make([]string, 0)
+	for t := start; !t.After(end); t = g.next(t) {
+		if len(buckets) >= HISTORY_MAX_POINTS {
+			c.JSON(http.StatusBadRequest, fmt.Sprintf("need no more than %d points", HISTORY_MAX_POINTS))
+			return
+		}
+		bucket := t.Format(g.layout)
+		buckets = append(buckets, bucket)
+		keys = append(keys, constructHistoryKey(namespace, key, name, bucket))
+	}
+	results, err := G_db.BatchPeek(ctx, keys...)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "get history successfully",
+		Data:   make([]Data, 0),
+	}
+	for index, item := range results {
+		// missing bucket means no views
+		value, _ := strconv.Atoi(item.value.(string))
+		errMsg.Data = append(errMsg.Data, Data{Key: buckets[index], Value: value})
+	}
+	c.JSON(http.StatusOK, errMsg)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseHistoryRange(t *testing.T) {
+	now := time.Date(2022, 12, 26, 10, 30, 0, 0, time.UTC)
+
+	start, end, err := parseHistoryRange(granularities["day"], "", "", now)
+	if err != nil || start.Format("2006-01-02") != "2022-11-27" || end.Format("2006-01-02") != "2022-12-26" {
+		t.Fail()
+	}
+	start, end, err = parseHistoryRange(granularities["hour"], "2022-12-25T23", "2022-12-26T01", now)
+	if err != nil || end.Sub(start) != 2*time.Hour {
+		t.Fail()
+	}
+	start, _, err = parseHistoryRange(granularities["month"], "", "2022-03", now)
+	if err != nil || start.Format("2006-01") != "2021-04" {
+		t.Fail()
+	}
+	_, _, err = parseHistoryRange(granularities["day"], "2022-12", "", now)
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestGetPvHistory(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/pv/create?namespace=histtest", nil)
+	router.ServeHTTP(w, req)
+	defer func() {
+		keys, _ := G_db.GetPrefixMatchKeys(ctx, "history@histtest@*")
+		G_db.Delete(ctx, keys...)
+		G_db.Delete(ctx, "namespace@histtest", "settings@histtest", "key@histtest@page", "call@create_pv", "call@increment_pv", "call@history_pv")
+	}()
+
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/pv/increment?namespace=histtest&key=page", nil)
+		router.ServeHTTP(w, req)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/pv/history?namespace=histtest&key=page&granularity=week", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Fail()
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/pv/history?namespace=histtest&key=page&from=2022-12-26&to=2022-12-01", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Fail()
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/pv/history?namespace=histtest&key=page&granularity=hour&from=2000-01-01T00", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Fail()
+	}
+
+	var errMsg ErrorMessage
+	for name, points := range map[string]int{"hour": 24, "day": 30, "month": 12} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/pv/history?namespace=histtest&key=page&granularity="+name, nil)
+		router.ServeHTTP(w, req)
+
+		err := json.Unmarshal(w.Body.Bytes(), &errMsg)
+		if w.Code != 200 || err != nil || len(errMsg.Data) != points {
+			fmt.Println(w.Body.String())
+			t.Fail()
+			continue
+		}
+		// the latest bucket has all views, the others have none
+		latest := errMsg.Data[points-1]
+		if latest.Key != time.Now().UTC().Format(granularities[name].layout) || int(latest.Value.(float64)) != 3 {
+			t.Fail()
+		}
+		if int(errMsg.Data[0].Value.(float64)) != 0 {
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// unique visitors of the memory and bolt backends are estimated by
+// HyperLogLog like redis, with 2^14 registers and a standard error of 0.81%.
+// Visitors are hashed and never kept, the hashes are counted exactly until
+// there are HLL_SPARSE_MAX of them, so a key takes at most 16KB
+const (
+	HLL_PRECISION  = 14
+	HLL_REGISTERS  = 1 << HLL_PRECISION
+	HLL_SPARSE_MAX = HLL_REGISTERS / 8
+)
+
+func hllHash(element string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(element))
+	// fnv spreads the high bits poorly, which pick the register
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func newHllEntry() *storeEntry {
+	return &storeEntry{Kind: kindHll, Hashes: make(map[uint64]bool)}
+}
+
+// hllFromSet converts the exact set kept by older versions
+func hllFromSet(entry *storeEntry) *storeEntry {
+	hll := newHllEntry()
+	hll.ExpireAt = entry.ExpireAt
+	for element := range entry.Set {
+		hll.hllAdd(element)
+	}
+	return hll
+}
+
+func (entry *storeEntry) hllAdd(element string) {
+	hash := hllHash(element)
+	if entry.Registers == nil {
+		entry.Hashes[hash] = true
+		if len(entry.Hashes) <= HLL_SPARSE_MAX {
+			return
+		}
+		// too many to count exactly
+		entry.Registers = make([]byte, HLL_REGISTERS)
+		for hash := range entry.Hashes {
+			entry.hllSetRegister(hash)
+		}
+		entry.Hashes = nil
+		return
+	}
+	entry.hllSetRegister(hash)
+}
+
+func (entry *storeEntry) hllSetRegister(hash uint64) {
+	index := hash >> (64 - HLL_PRECISION)
+	// the guard bit bounds the rank when the rest are all zeros
+	rank := byte(bits.LeadingZeros64(hash<<HLL_PRECISION|1<<(HLL_PRECISION-1)) + 1)
+	if rank > entry.Registers[index] {
+		entry.Registers[index] = rank
+	}
+}
+
+func (entry *storeEntry) hllCount() int64 {
+	if entry.Registers == nil {
+		return int64(len(entry.Hashes))
+	}
+	m := float64(HLL_REGISTERS)
+	sum := 0.0
+	zeros := 0
+	for _, register := range entry.Registers {
+		sum += math.Ldexp(1, -int(register))
+		if register == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// linear counting is better for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5)
+}
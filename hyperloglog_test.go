@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	entry := newHllEntry()
+	for i := 0; i < HLL_SPARSE_MAX; i++ {
+		entry.hllAdd(fmt.Sprint("visitor-", i))
+		entry.hllAdd(fmt.Sprint("visitor-", i))
+	}
+	// exact while sparse
+	if entry.Registers != nil || entry.hllCount() != HLL_SPARSE_MAX {
+		t.Fail()
+	}
+
+	for _, n := range []int{HLL_SPARSE_MAX + 1, 20000, 200000} {
+		entry := newHllEntry()
+		for i := 0; i < n; i++ {
+			entry.hllAdd(fmt.Sprint("visitor-", i))
+		}
+		if entry.Hashes != nil || len(entry.Registers) != HLL_REGISTERS {
+			t.Fail()
+		}
+		if diff := math.Abs(float64(entry.hllCount()-int64(n))) / float64(n); diff > 0.03 {
+			t.Errorf("count of %d visitors is %d", n, entry.hllCount())
+		}
+	}
+}
+
+func TestHyperLogLogFromSet(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	// kept exactly by older versions
+	db.entries["uv@test@old"] = &storeEntry{Kind: kindSet, Set: map[string]bool{"a": true, "b": true}}
+	ret, err := db.PfAdd(ctx, "uv@test@old", "b", "c")
+	if err != nil || ret.value.(int64) != 3 {
+		t.Fail()
+	}
+	if entry := db.entries["uv@test@old"]; entry.Kind != kindHll || entry.Set != nil {
+		t.Error("visitors should not be kept")
+	}
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kindString = iota
+	// exact unique visitors of older versions, converted to kindHll on access
+	kindSet
+	kindZSet
+	kindHll
+)
+
+type storeEntry struct {
+	Kind   int
+	Value  string
+	Set    map[string]bool
+	Scores map[string]int64
+	// sparse and dense representations of kindHll
+	Hashes    map[uint64]bool
+	Registers []byte
+	// unix nano, 0 means no ttl
+	ExpireAt int64
+}
+
+// keyspace is the raw key/entry storage of an embedded backend
+type keyspace interface {
+	load(key string) (*storeEntry, error)
+	save(key string, entry *storeEntry) error
+	remove(key string) error
+	// iterate keys with the given prefix
+	scan(prefix string, fn func(key string, entry *storeEntry) error) error
+}
+
+// commands implements the redis-like semantics on a keyspace,
+// shared by the memory and bolt backends
+type commands struct {
+	ks  keyspace
+	now time.Time
+	// default ttl of keys
+	ttl time.Duration
+}
+
+// lookup returns the live entry of key, expired entry is removed
+func (cmd *commands) lookup(key string) (*storeEntry, error) {
+	entry, err := cmd.ks.load(key)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	if cmd.isExpired(entry) {
+		return nil, cmd.ks.remove(key)
+	}
+	return entry, nil
+}
+
+func (cmd *commands) isExpired(entry *storeEntry) bool {
+	return entry.ExpireAt != 0 && entry.ExpireAt <= cmd.now.UnixNano()
+}
+
+func (cmd *commands) expireAt() int64 {
+	return cmd.now.Add(cmd.ttl).UnixNano()
+}
+
+func (cmd *commands) refresh(key string, entry *storeEntry) error {
+	entry.ExpireAt = cmd.expireAt()
+	return cmd.ks.save(key, entry)
+}
+
+func (cmd *commands) refreshExpire(key string) error {
+	entry, err := cmd.lookup(key)
+	if err != nil || entry == nil {
+		return err
+	}
+	return cmd.refresh(key, entry)
+}
+
+func (cmd *commands) expire(ttl time.Duration, keys ...string) error {
+	for _, key := range keys {
+		entry, err := cmd.lookup(key)
+		if err == nil && entry != nil {
+			entry.ExpireAt = cmd.now.Add(ttl).UnixNano()
+			err = cmd.ks.save(key, entry)
+		}
+		if err != nil {
+			errMsg := fmt.Errorf("expire key[%s] failed. err[%v]", key, err)
+			return errMsg
+		}
+	}
+	return nil
+}
+
+func (cmd *commands) set(key string, value interface{}, use_ttl bool) error {
+	entry := &storeEntry{Kind: kindString, Value: fmt.Sprint(value)}
+	if use_ttl {
+		entry.ExpireAt = cmd.expireAt()
+	}
+	if err := cmd.ks.save(key, entry); err != nil {
+		errMsg := fmt.Errorf("Set key[%s] with value[%s] failed. err[%v]", key, value, err)
+		return errMsg
+	}
+	return nil
+}
+
+func (cmd *commands) setNX(key string, value interface{}, ttl time.Duration) (bool, error) {
+	entry, err := cmd.lookup(key)
+	if err != nil {
+		errMsg := fmt.Errorf("SetNX key[%s] with value[%s] failed. err[%v]", key, value, err)
+		return false, errMsg
+	}
+	if entry != nil {
+		return false, nil
+	}
+	entry = &storeEntry{Kind: kindString, Value: fmt.Sprint(value), ExpireAt: cmd.now.Add(ttl).UnixNano()}
+	if err = cmd.ks.save(key, entry); err != nil {
+		errMsg := fmt.Errorf("SetNX key[%s] with value[%s] failed. err[%v]", key, value, err)
+		return false, errMsg
+	}
+	return true, nil
+}
+
+func (cmd *commands) get(key string) (*RedisResult, error) {
+	entry, err := cmd.lookup(key)
+	switch {
+	case err != nil:
+		errMsg := fmt.Errorf("get key[%s] failed. err[%v]", key, err)
+		return nil, errMsg
+	case entry == nil:
+		errMsg := fmt.Errorf("key[%s] does not exist", key)
+		return nil, errMsg
+	case entry.Kind != kindString:
+		errMsg := fmt.Errorf("get key[%s] failed. err[wrong type]", key)
+		return nil, errMsg
+	case entry.Value == "":
+		errMsg := fmt.Errorf("key[%s] is empty", key)
+		return nil, errMsg
+	}
+	if err = cmd.refresh(key, entry); err != nil {
+		errMsg := fmt.Errorf("expire key[%s] failed. err[%v]", key, err)
+		return nil, errMsg
+	}
+	return &RedisResult{key: key, value: entry.Value}, nil
+}
+
+func (cmd *commands) batchGet(keys ...string) ([]RedisResult, error) {
+	results := make([]RedisResult, 0)
+	for _, key := range keys {
+		entry, err := cmd.lookup(key)
+		if err != nil {
+			errMsg := fmt.Errorf("batch get key[%s] failed. err[%v]", key, err)
+			return nil, errMsg
+		}
+		value := ""
+		if entry != nil && entry.Kind == kindString {
+			if err = cmd.refresh(key, entry); err != nil {
+				errMsg := fmt.Errorf("expire key[%s] failed. err[%v]", key, err)
+				return nil, errMsg
+			}
+			value = entry.Value
+		}
+		results = append(results, RedisResult{key: key, value: value})
+	}
+	return results, nil
+}
+
+func (cmd *commands) batchPeek(keys ...string) ([]RedisResult, error) {
+	results := make([]RedisResult, 0)
+	for _, key := range keys {
+		entry, err := cmd.lookup(key)
+		if err != nil {
+			errMsg := fmt.Errorf("batch peek key[%s] failed. err[%v]", key, err)
+			return nil, errMsg
+		}
+		value := ""
+		if entry != nil && entry.Kind == kindString {
+			value = entry.Value
+		}
+		results = append(results, RedisResult{key: key, value: value})
+	}
+	return results, nil
+}
+
+func (cmd *commands) incr(key string) (*RedisResult, error) {
+	return cmd.incrWithTTL(key, cmd.ttl)
+}
+
+func (cmd *commands) batchIncr(keys []string, ttls []time.Duration) ([]RedisResult, error) {
+	results := make([]RedisResult, 0)
+	for index, key := range keys {
+		result, err := cmd.incrWithTTL(key, ttls[index])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+func (cmd *commands) batchIncrBy(keys []string, bys []int64) ([]RedisResult, error) {
+	// check all keys first, so nothing changes if any of them fails
+	for _, key := range keys {
+		entry, err := cmd.lookup(key)
+		if err != nil {
+			errMsg := fmt.Errorf("incr key[%s] failed. err[%v]", key, err)
+			return nil, errMsg
+		}
+		if entry == nil {
+			continue
+		}
+		if _, err = strconv.ParseInt(entry.Value, 10, 64); entry.Kind != kindString || err != nil {
+			errMsg := fmt.Errorf("incr key[%s] failed. err[value is not an integer]", key)
+			return nil, errMsg
+		}
+	}
+	results := make([]RedisResult, 0)
+	for index, key := range keys {
+		result, err := cmd.incrBy(key, bys[index], cmd.ttl, false)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+func (cmd *commands) incrWithTTL(key string, ttl time.Duration) (*RedisResult, error) {
+	return cmd.incrBy(key, 1, ttl, false)
+}
+
+func (cmd *commands) incrBy(key string, by int64, ttl time.Duration, floorAtZero bool) (*RedisResult, error) {
+	entry, err := cmd.lookup(key)
+	if err != nil {
+		errMsg := fmt.Errorf("incr key[%s] failed. err[%v]", key, err)
+		return nil, errMsg
+	}
+	if entry == nil {
+		entry = &storeEntry{Kind: kindString, Value: "0"}
+	}
+	value, err := strconv.ParseInt(entry.Value, 10, 64)
+	if entry.Kind != kindString || err != nil {
+		errMsg := fmt.Errorf("incr key[%s] failed. err[value is not an integer]", key)
+		return nil, errMsg
+	}
+	value += by
+	if floorAtZero && value < 0 {
+		value = 0
+	}
+	entry.Value = strconv.FormatInt(value, 10)
+	entry.ExpireAt = cmd.now.Add(ttl).UnixNano()
+	if err = cmd.ks.save(key, entry); err != nil {
+		errMsg := fmt.Errorf("incr key[%s] failed. err[%v]", key, err)
+		return nil, errMsg
+	}
+	return &RedisResult{key: key, value: value}, nil
+}
+
+func (cmd *commands) delete(keys ...string) (int64, error) {
+	var cnt int64
+	for _, key := range keys {
+		entry, err := cmd.lookup(key)
+		if err != nil {
+			return cnt, err
+		}
+		if entry == nil {
+			continue
+		}
+		if err = cmd.ks.remove(key); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, nil
+}
+
+func (cmd *commands) keys(pattern string) ([]string, error) {
+	allKeys := make([]string, 0)
+	err := cmd.ks.scan(patternPrefix(pattern), func(key string, entry *storeEntry) error {
+		if !cmd.isExpired(entry) && matchPattern(pattern, key) {
+			allKeys = append(allKeys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		errMsg := fmt.Errorf("scan pattern[%s] failed. err:[%v]", pattern, err)
+		return nil, errMsg
+	}
+	sort.Strings(allKeys)
+	return allKeys, nil
+}
+
+func (cmd *commands) deleteMatch(pattern string) (int64, error) {
+	keys, err := cmd.keys(pattern)
+	if err != nil {
+		return 0, err
+	}
+	return cmd.delete(keys...)
+}
+
+func (cmd *commands) rename(keys []string, newKeys []string) error {
+	// check all new keys first, so nothing changes if any of them exists
+	for _, key := range newKeys {
+		entry, err := cmd.lookup(key)
+		if err != nil {
+			errMsg := fmt.Errorf("rename to key[%s] failed. err[%v]", key, err)
+			return errMsg
+		}
+		if entry != nil {
+			errMsg := fmt.Errorf("rename to key[%s] failed. err[key exists]", key)
+			return errMsg
+		}
+	}
+	for index, key := range keys {
+		entry, err := cmd.lookup(key)
+		if err == nil && entry != nil {
+			err = cmd.ks.save(newKeys[index], entry)
+		}
+		if err == nil && entry != nil {
+			err = cmd.ks.remove(key)
+		}
+		if err != nil {
+			errMsg := fmt.Errorf("rename key[%s] failed. err[%v]", key, err)
+			return errMsg
+		}
+	}
+	return nil
+}
+
+// lookupHll returns the live HyperLogLog of key, nil if it doesn't exist
+func (cmd *commands) lookupHll(key string) (*storeEntry, error) {
+	entry, err := cmd.lookup(key)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	switch entry.Kind {
+	case kindHll:
+		return entry, nil
+	case kindSet:
+		return hllFromSet(entry), nil
+	}
+	return nil, fmt.Errorf("wrong type")
+}
+
+func (cmd *commands) pfAdd(key string, elements ...interface{}) (*RedisResult, error) {
+	entry, err := cmd.lookupHll(key)
+	if err != nil {
+		errMsg := fmt.Errorf("pfadd key[%s] failed. err[%v]", key, err)
+		return nil, errMsg
+	}
+	if entry == nil {
+		entry = newHllEntry()
+	}
+	for _, element := range elements {
+		entry.hllAdd(fmt.Sprint(element))
+	}
+	if err = cmd.refresh(key, entry); err != nil {
+		errMsg := fmt.Errorf("pfadd key[%s] failed. err[%v]", key, err)
+		return nil, errMsg
+	}
+	return &RedisResult{key: key, value: entry.hllCount()}, nil
+}
+
+func (cmd *commands) pfCount(key string) (*RedisResult, error) {
+	entry, err := cmd.lookupHll(key)
+	switch {
+	case err != nil:
+		errMsg := fmt.Errorf("pfcount key[%s] failed. err[%v]", key, err)
+		return nil, errMsg
+	case entry == nil:
+		errMsg := fmt.Errorf("key[%s] does not exist", key)
+		return nil, errMsg
+	}
+	if err = cmd.refresh(key, entry); err != nil {
+		errMsg := fmt.Errorf("expire key[%s] failed. err[%v]", key, err)
+		return nil, errMsg
+	}
+	return &RedisResult{key: key, value: entry.hllCount()}, nil
+}
+
+func (cmd *commands) batchPfCount(keys ...string) ([]RedisResult, error) {
+	results := make([]RedisResult, 0)
+	for _, key := range keys {
+		entry, err := cmd.lookup(key)
+		if err != nil {
+			errMsg := fmt.Errorf("batch pfcount key[%s] failed. err[%v]", key, err)
+			return nil, errMsg
+		}
+		var value int64
+		if entry != nil && (entry.Kind == kindHll || entry.Kind == kindSet) {
+			if entry.Kind == kindSet {
+				entry = hllFromSet(entry)
+			}
+			if err = cmd.refresh(key, entry); err != nil {
+				errMsg := fmt.Errorf("expire key[%s] failed. err[%v]", key, err)
+				return nil, errMsg
+			}
+			value = entry.hllCount()
+		}
+		results = append(results, RedisResult{key: key, value: value})
+	}
+	return results, nil
+}
+
+// lookupZSet returns the live sorted set of key, or a new one if it doesn't exist
+func (cmd *commands) lookupZSet(key string) (*storeEntry, error) {
+	entry, err := cmd.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return &storeEntry{Kind: kindZSet, Scores: make(map[string]int64)}, nil
+	}
+	if entry.Kind != kindZSet {
+		return nil, fmt.Errorf("wrong type")
+	}
+	return entry, nil
+}
+
+func (cmd *commands) batchZIncrBy(keys []string, ttls []time.Duration, member string, by int64) error {
+	for index, key := range keys {
+		entry, err := cmd.lookupZSet(key)
+		if err != nil {
+			errMsg := fmt.Errorf("zincrby key[%s] failed. err[%v]", key, err)
+			return errMsg
+		}
+		entry.Scores[member] += by
+		entry.ExpireAt = cmd.now.Add(ttls[index]).UnixNano()
+		if err = cmd.ks.save(key, entry); err != nil {
+			errMsg := fmt.Errorf("zincrby key[%s] failed. err[%v]", key, err)
+			return errMsg
+		}
+	}
+	return nil
+}
+
+func (cmd *commands) zAdd(key string, member string, score int64) error {
+	entry, err := cmd.lookupZSet(key)
+	if err != nil {
+		errMsg := fmt.Errorf("zadd key[%s] failed. err[%v]", key, err)
+		return errMsg
+	}
+	entry.Scores[member] = score
+	if err = cmd.refresh(key, entry); err != nil {
+		errMsg := fmt.Errorf("zadd key[%s] failed. err[%v]", key, err)
+		return errMsg
+	}
+	return nil
+}
+
+func (cmd *commands) zRem(keys []string, member string) error {
+	for _, key := range keys {
+		entry, err := cmd.lookup(key)
+		if err != nil {
+			errMsg := fmt.Errorf("zrem key[%s] failed. err[%v]", key, err)
+			return errMsg
+		}
+		if entry == nil || entry.Kind != kindZSet {
+			continue
+		}
+		delete(entry.Scores, member)
+		if len(entry.Scores) == 0 {
+			err = cmd.ks.remove(key)
+		} else {
+			err = cmd.ks.save(key, entry)
+		}
+		if err != nil {
+			errMsg := fmt.Errorf("zrem key[%s] failed. err[%v]", key, err)
+			return errMsg
+		}
+	}
+	return nil
+}
+
+func (cmd *commands) zTop(keys []string, limit int) ([]RedisResult, error) {
+	scores := make(map[string]int64)
+	for _, key := range keys {
+		entry, err := cmd.lookup(key)
+		if err != nil {
+			errMsg := fmt.Errorf("ztop key[%s] failed. err[%v]", key, err)
+			return nil, errMsg
+		}
+		if entry == nil || entry.Kind != kindZSet {
+			continue
+		}
+		for member, score := range entry.Scores {
+			scores[member] += score
+		}
+	}
+	results := make([]RedisResult, 0)
+	for member, score := range scores {
+		results = append(results, RedisResult{key: member, value: score})
+	}
+	// highest score first, then reverse lexicographical order like redis
+	sort.Slice(results, func(i, j int) bool {
+		si, sj := results[i].value.(int64), results[j].value.(int64)
+		if si != sj {
+			return si > sj
+		}
+		return results[i].key > results[j].key
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// removeExpired drops all expired entries
+func (cmd *commands) removeExpired() error {
+	expired := make([]string, 0)
+	err := cmd.ks.scan("", func(key string, entry *storeEntry) error {
+		if cmd.isExpired(entry) {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range expired {
+		if err = cmd.ks.remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patternPrefix returns the literal prefix of the glob-style pattern
+func patternPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// escapePattern escapes the special characters of glob-style patterns,
+// so s is matched literally
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("*?[]\\", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// matchPattern reports whether s matches the glob-style pattern like redis,
+// supports `*`, `?`, `[...]` and `\` escaping
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := 1
+			for end < len(pattern) && pattern[end] != ']' {
+				if pattern[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(pattern) {
+				// no closing bracket, match literally
+				if s[0] != '[' {
+					return false
+				}
+				s = s[1:]
+				pattern = pattern[1:]
+				continue
+			}
+			if !matchClass(pattern[1:end], s[0]) {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+func matchClass(class string, c byte) bool {
+	negate := false
+	if len(class) > 0 && class[0] == '^' {
+		negate = true
+		class = class[1:]
+	}
+	matched := false
+	for i := 0; i < len(class); i++ {
+		switch {
+		case class[i] == '\\' && i+1 < len(class):
+			i++
+			if class[i] == c {
+				matched = true
+			}
+		case i+2 < len(class) && class[i+1] == '-':
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if lo <= c && c <= hi {
+				matched = true
+			}
+			i += 2
+		default:
+			if class[i] == c {
+				matched = true
+			}
+		}
+	}
+	return matched != negate
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		matched bool
+	}{
+		{"key@test@*", "key@test@a/b", true},
+		{"key@test@*", "key@other@a", false},
+		{"*", "", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+	}
+	for _, c := range cases {
+		if matchPattern(c.pattern, c.s) != c.matched {
+			t.Errorf("match %s with %s should be %v", c.pattern, c.s, c.matched)
+		}
+	}
+}
+
+func TestPatternPrefix(t *testing.T) {
+	if patternPrefix("key@test@*") != "key@test@" || patternPrefix("call@*") != "call@" ||
+		patternPrefix("h?llo") != "h" || patternPrefix("hello") != "hello" {
+		t.Fail()
+	}
+}
+
+func TestEscapePattern(t *testing.T) {
+	pattern := escapePattern("a*b?[c]\\") + "@*"
+	if !matchPattern(pattern, "a*b?[c]\\@x") || matchPattern(pattern, "axb?[c]\\@x") {
+		t.Fail()
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// all-time leaderboard of namespace
+func constructTopKey(namespace string) string {
+	return fmt.Sprintf("top@%s", namespace)
+}
+
+// daily leaderboard of namespace, summed up for windows like `7d`
+func constructDailyTopKey(namespace string, day time.Time) string {
+	return fmt.Sprintf("top@%s@%s", namespace, day.UTC().Format(granularities["day"].layout))
+}
+
+// keys of the daily leaderboards in the latest `days` days, counted back
+// in UTC like the keys, as local days across DST are not 24 hours
+func constructWindowTopKeys(namespace string, days int, now time.Time) []string {
+	keys := make([]string, 0)
+	for i := 0; i < days; i++ {
+		keys = append(keys, constructDailyTopKey(namespace, now.UTC().AddDate(0, 0, -i)))
+	}
+	return keys
+}
+
+// the all-time leaderboard lives as long as the keys of namespace, while
+// daily ones only last for the longest window
+func recordLeaderboard(ctx context.Context, settings *namespaceSettings, namespace, key string, now time.Time) error {
+	keys := []string{constructTopKey(namespace), constructDailyTopKey(namespace, now)}
+	ttls := []time.Duration{G_config.Store.KeyTTL, LEADERBOARD_MAX_WINDOW_DAYS * 24 * time.Hour}
+	if err := G_db.BatchZIncrBy(ctx, keys, ttls, key, 1); err != nil {
+		return err
+	}
+	return settings.expireKeys(ctx, constructTopKey(namespace))
+}
+
+func resetLeaderboard(ctx context.Context, settings *namespaceSettings, namespace, key string, value int64) error {
+	if err := G_db.ZAdd(ctx, constructTopKey(namespace), key, value); err != nil {
+		return err
+	}
+	return settings.expireKeys(ctx, constructTopKey(namespace))
+}
+
+func removeFromLeaderboard(ctx context.Context, namespace, key string, now time.Time) error {
+	keys := constructWindowTopKeys(namespace, LEADERBOARD_MAX_WINDOW_DAYS, now)
+	keys = append(keys, constructTopKey(namespace))
+	return G_db.ZRem(ctx, keys, key)
+}
+
+// parse window like `all` or `7d`, return 0 for all-time
+func parseWindow(window string) (int, error) {
+	if window == "" || window == "all" {
+		return 0, nil
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(window, "d"))
+	if !strings.HasSuffix(window, "d") || err != nil || days < 1 || days > LEADERBOARD_MAX_WINDOW_DAYS {
+		return 0, fmt.Errorf("invalid window[%s]", window)
+	}
+	return days, nil
+}
+
+func GetPvTop(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "top_pv")
+
+	namespace := c.Query("namespace")
+	if ok := checkNamespace(namespace, c); !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(LEADERBOARD_DEFAULT_LIMIT)))
+	if err != nil || limit < 1 || limit > LEADERBOARD_MAX_LIMIT {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("need limit between 1 and %d", LEADERBOARD_MAX_LIMIT))
+		return
+	}
+	days, err := parseWindow(c.Query("window"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("need window of all or 1d to %dd", LEADERBOARD_MAX_WINDOW_DAYS))
+		return
+	}
+	if ok := isNamespaceValid(namespace, c); !ok {
+		return
+	}
+	if _, ok := isReadAllowed(namespace, true, c); !ok {
+		return
+	}
+
+	keys := []string{constructTopKey(namespace)}
+	if days > 0 {
+		keys = constructWindowTopKeys(namespace, days, time.Now())
+	}
+	results, err := G_db.ZTop(ctx, keys, limit)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "get top keys successfully",
+		Data:   make([]Data, 0),
+	}
+	for _, item := range results {
+		errMsg.Data = append(errMsg.Data, Data{Key: constructKey(namespace, item.key), Value: item.value})
+	}
+	c.JSON(http.StatusOK, errMsg)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	if days, err := parseWindow(""); days != 0 || err != nil {
+		t.Fail()
+	}
+	if days, err := parseWindow("all"); days != 0 || err != nil {
+		t.Fail()
+	}
+	if days, err := parseWindow("7d"); days != 7 || err != nil {
+		t.Fail()
+	}
+	for _, window := range []string{"7", "0d", "d", "1000d", "1w"} {
+		if _, err := parseWindow(window); err == nil {
+			t.Errorf("window %s should be invalid", window)
+		}
+	}
+}
+
+func TestConstructWindowTopKeys(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 2026-03-08 23:30 UTC, the day DST starts
+	now := time.Date(2026, 3, 8, 19, 30, 0, 0, location)
+	keys := constructWindowTopKeys("test", 3, now)
+	if len(keys) != 3 || keys[0] != "top@test@2026-03-08" || keys[1] != "top@test@2026-03-07" || keys[2] != "top@test@2026-03-06" {
+		t.Error(keys)
+	}
+}
+
+func TestGetPvTop(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/pv/create?namespace=toptest", nil)
+	router.ServeHTTP(w, req)
+	defer func() {
+		for _, pattern := range []string{"history@toptest@*", "top@toptest*", "key@toptest@*"} {
+			keys, _ := G_db.GetPrefixMatchKeys(ctx, pattern)
+			G_db.Delete(ctx, keys...)
+		}
+		G_db.Delete(ctx, "namespace@toptest", "settings@toptest", "call@create_pv", "call@increment_pv", "call@reset_pv",
+			"call@delete_pv", "call@top_pv")
+	}()
+
+	for key, times := range map[string]int{"a": 3, "b": 2, "c": 1} {
+		for i := 0; i < times; i++ {
+			w = httptest.NewRecorder()
+			req, _ = http.NewRequest("POST", "/pv/increment?namespace=toptest&key="+key, nil)
+			router.ServeHTTP(w, req)
+		}
+	}
+
+	getTop := func(query string) ErrorMessage {
+		var errMsg ErrorMessage
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/pv/top?namespace=toptest"+query, nil)
+		router.ServeHTTP(w, req)
+		if err := json.Unmarshal(w.Body.Bytes(), &errMsg); err != nil || w.Code != 200 {
+			fmt.Println(w.Body.String())
+			t.Fail()
+		}
+		return errMsg
+	}
+
+	errMsg := getTop("&limit=2")
+	if len(errMsg.Data) != 2 || errMsg.Data[0].Key != "key@toptest@a" || int(errMsg.Data[0].Value.(float64)) != 3 ||
+		errMsg.Data[1].Key != "key@toptest@b" {
+		fmt.Println(errMsg)
+		t.Fail()
+	}
+	errMsg = getTop("&window=7d")
+	if len(errMsg.Data) != 3 || errMsg.Data[2].Key != "key@toptest@c" {
+		fmt.Println(errMsg)
+		t.Fail()
+	}
+
+	// kept in sync by reset and delete
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/pv/reset?namespace=toptest&secret=toptest&key=c&value=10", nil)
+	router.ServeHTTP(w, req)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/pv/delete?namespace=toptest&secret=toptest&key=a", nil)
+	router.ServeHTTP(w, req)
+
+	errMsg = getTop("")
+	if len(errMsg.Data) != 2 || errMsg.Data[0].Key != "key@toptest@c" || int(errMsg.Data[0].Value.(float64)) != 10 {
+		fmt.Println(errMsg)
+		t.Fail()
+	}
+	errMsg = getTop("&window=1d")
+	if len(errMsg.Data) != 2 || errMsg.Data[0].Key != "key@toptest@b" {
+		fmt.Println(errMsg)
+		t.Fail()
+	}
+
+	for _, query := range []string{"&limit=0", "&limit=1000", "&window=1w"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/pv/top?namespace=toptest"+query, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != 400 {
+			t.Fail()
+		}
+	}
+}
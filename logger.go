@@ -2,18 +2,28 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+// LogFormat prints fields as `key=value` sorted by key
 type LogFormat struct {
 	TimestampFormat string
 }
@@ -33,9 +43,13 @@ func (f *LogFormat) Format(entry *logrus.Entry) ([]byte, error) {
 	b.WriteString(entry.Time.Format(f.TimestampFormat))
 	b.WriteString(" ")
 
-	for _, value := range entry.Data {
-		fmt.Fprint(b, value)
-		b.WriteString(" ")
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s=%v ", key, entry.Data[key])
 	}
 
 	if entry.Message != "" {
@@ -47,62 +61,112 @@ func (f *LogFormat) Format(entry *logrus.Entry) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-func NewLogger() *logrus.Logger {
-	logFilePath := LOG_FILE_PATH
-	logFileName := LOG_FILE_NAME
-
-	fileName := path.Join(logFilePath, logFileName)
+func NewLogger(config LogConfig) *logrus.Logger {
+	fileName := path.Join(config.Path, config.Name)
 
-	if _, err := os.Stat(logFilePath); os.IsNotExist(err) {
-		err = os.Mkdir(logFilePath, os.ModePerm)
-		if err != nil {
-			fmt.Println("Create log director failed. err:", err)
-		}
-	}
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
-		_, err = os.Create(fileName)
-		if err != nil {
-			fmt.Println("Create log file failed. err:", err)
-		}
+	// new logger
+	logger := logrus.New()
+	logger.SetReportCaller(true)
+	writer, err := newLogWriter(fileName, config)
+	if err != nil {
+		// keep serving with logs on stdout only
+		fmt.Fprintln(os.Stderr, "log to stdout only. err:", err)
+		logger.Out = os.Stdout
+	} else {
+		logger.Out = io.MultiWriter(writer, os.Stdout)
 	}
-
-	// file to write
-	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, os.ModeAppend)
+	// validated with the config
+	level, err := logrus.ParseLevel(config.Level)
 	if err != nil {
-		fmt.Println("Open file failed. err:", err)
+		level = logrus.DebugLevel
+	}
+	logger.SetLevel(level)
+	if config.Format == LOG_FORMAT_JSON {
+		logger.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+		})
+	} else {
+		logger.SetFormatter(&LogFormat{
+			TimestampFormat: "2006-01-02 15:04:05",
+		})
 	}
+	logger.AddHook(contextHook{})
 
-	// new logger
-	logger := logrus.New()
-	logger.SetReportCaller(true)
-	logger.Out = io.MultiWriter(f, os.Stdout)
-	logger.SetLevel(logrus.DebugLevel)
-	logger.SetFormatter(&LogFormat{
-		TimestampFormat: "2006-01-02 15:04:05",
-	})
+	return logger
+}
 
-	// set rotatelogs
-	_, err = rotatelogs.New(
-		strings.Split(fileName, ".")[0]+".%Y%m%d.log",
+func CleanLog() error {
+	return os.RemoveAll(DefaultConfig().Log.Path)
+}
 
-		rotatelogs.WithLinkName(fileName),
+type requestIDKey struct{}
 
-		rotatelogs.WithMaxAge(7*24*time.Hour),
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
 
-		rotatelogs.WithRotationTime(24*time.Hour),
-	)
-	if err != nil {
-		panic("init logger failed. err: " + err.Error())
+// isValidRequestID keeps ids from clients short and free of characters
+// which could break log lines
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > REQUEST_ID_MAX_LENGTH {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:", r)) {
+			return false
+		}
 	}
+	return true
+}
 
-	return logger
+func generateRequestID() string {
+	b := make([]byte, REQUEST_ID_LENGTH)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
 }
 
-func CleanLog() error {
-	return os.RemoveAll(LOG_FILE_PATH)
+// RequestIDMiddleware takes X-Request-ID from the client or generates one,
+// echoes it in the response and keeps it in the request context, so
+// `G_logger.WithContext(ctx)` logs it
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if !isValidRequestID(id) {
+			id = generateRequestID()
+		}
+		c.Header("X-Request-ID", id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, id))
+		c.Next()
+	}
+}
+
+// contextHook adds the request id and trace id of the context of entry,
+// which is set by `WithContext`
+type contextHook struct{}
+
+func (contextHook) Levels() []logrus.Level {
+	return logrus.AllLevels
 }
 
-func LoggerMiddleware(logger *logrus.Logger) gin.HandlerFunc {
+func (contextHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	if id := requestIDFromContext(entry.Context); id != "" {
+		entry.Data["request_id"] = id
+	}
+	if spanContext := trace.SpanContextFromContext(entry.Context); spanContext.HasTraceID() {
+		entry.Data["trace_id"] = spanContext.TraceID().String()
+	}
+	return nil
+}
+
+// LoggerMiddleware writes requests to the access log, or to logger
+// without the access log
+func LoggerMiddleware(logger *logrus.Logger, accessLog *AccessLogger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		startTime := time.Now()
 
@@ -120,7 +184,13 @@ func LoggerMiddleware(logger *logrus.Logger) gin.HandlerFunc {
 
 		clientIp := c.ClientIP()
 
-		logger.WithFields(logrus.Fields{
+		observeRequest(c, latencyTime)
+
+		if accessLog != nil {
+			accessLog.Log(c, startTime, latencyTime)
+			return
+		}
+		logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
 			"status_code":  statusCode,
 			"latency_time": latencyTime,
 			"client_ip":    clientIp,
@@ -2,27 +2,37 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
+func MockNewLogger() *logrus.Logger {
+	return NewLogger(DefaultConfig().Log)
+}
+
 func TestNewLogger(t *testing.T) {
-	logger := NewLogger()
+	logger := MockNewLogger()
 	if logger == nil {
 		t.Error("NewLogger failed")
 	}
 	logger.Info("hello world")
 
-	if _, err := os.Stat(LOG_FILE_PATH); os.IsNotExist(err) {
+	if _, err := os.Stat(DefaultConfig().Log.Path); os.IsNotExist(err) {
 		t.Error("log file path not exist")
 	}
 	defer CleanLog()
 
-	fileName := path.Join(LOG_FILE_PATH, LOG_FILE_NAME)
+	fileName := path.Join(DefaultConfig().Log.Path, DefaultConfig().Log.Name)
 	file, err := os.Open(fileName)
 	if err != nil {
 		t.Fail()
@@ -40,15 +50,107 @@ func TestNewLogger(t *testing.T) {
 }
 
 func TestLoggerMiddleware(t *testing.T) {
-	logger := NewLogger()
+	logger := MockNewLogger()
 	if logger == nil {
 		t.Error("NewLogger failed")
 	}
 	r := gin.Default()
-	r.Use(LoggerMiddleware(logger))
+	r.Use(LoggerMiddleware(logger, nil))
 
-	if _, err := os.Stat(LOG_FILE_PATH); os.IsNotExist(err) {
+	if _, err := os.Stat(DefaultConfig().Log.Path); os.IsNotExist(err) {
 		t.Error("log file path not exist")
 	}
-	defer os.RemoveAll(LOG_FILE_PATH)
+	defer os.RemoveAll(DefaultConfig().Log.Path)
+}
+
+func TestLogFormat(t *testing.T) {
+	entry := logrus.NewEntry(logrus.New())
+	entry.Data = logrus.Fields{"b": 2, "a": 1, "c": "x"}
+	entry.Message = "hello"
+	entry.Level = logrus.WarnLevel
+	entry.Time = time.Date(2022, 12, 26, 0, 0, 0, 0, time.UTC)
+	content, err := (&LogFormat{TimestampFormat: "2006-01-02 15:04:05"}).Format(entry)
+	if err != nil || string(content) != "[WARNING] 2022-12-26 00:00:00 a=1 b=2 c=x hello \n" {
+		t.Errorf("unexpected log line %q", content)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, requestIDFromContext(c.Request.Context()))
+	})
+	if w, _ := MockRequest(r, "GET", "/ping", map[string]string{"X-Request-ID": "abc-123"}, ""); w.Header().Get("X-Request-ID") != "abc-123" || w.Body.String() != "abc-123" {
+		t.Fail()
+	}
+	for _, id := range []string{"", "a b", "line\nbreak", strings.Repeat("a", REQUEST_ID_MAX_LENGTH+1)} {
+		w, _ := MockRequest(r, "GET", "/ping", map[string]string{"X-Request-ID": id}, "")
+		generated := w.Header().Get("X-Request-ID")
+		if generated == id || len(generated) != REQUEST_ID_LENGTH*2 || w.Body.String() != generated {
+			t.Errorf("request id %q should be replaced", id)
+		}
+	}
+}
+
+func TestJSONLog(t *testing.T) {
+	config := DefaultConfig().Log
+	config.Format = LOG_FORMAT_JSON
+	config.Level = "info"
+	logger := NewLogger(config)
+	defer CleanLog()
+	var buf bytes.Buffer
+	logger.Out = &buf
+
+	logger.Debug("hidden")
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.Use(LoggerMiddleware(logger, nil))
+	AddRouters(r, MockNewRedisClient(), logger)
+	defer G_db.Delete(ctx, "call@get_pv")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/pv/get?namespace=jsonlogtest&key=a", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	r.ServeHTTP(w, req)
+
+	// the warning of the handler and the access log
+	levels := make([]string, 0)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			t.Fatal(err, scanner.Text())
+		}
+		if fields["request_id"] != "req-123" || fields["time"] == nil || fields["msg"] == nil {
+			t.Errorf("unexpected log line %s", scanner.Text())
+		}
+		levels = append(levels, fields["level"].(string))
+	}
+	if strings.Join(levels, ",") != "warning,info" {
+		t.Errorf("unexpected levels %v", levels)
+	}
+}
+
+func TestComponentLog(t *testing.T) {
+	config := DefaultConfig().Log
+	config.Format = LOG_FORMAT_JSON
+	logger := NewLogger(config)
+	defer CleanLog()
+	var buf bytes.Buffer
+	logger.Out = &buf
+
+	// logs without request still have stable keys
+	G_config.BotFilter.Enabled = true
+	G_config.BotFilter.Cidrs = []string{"not a cidr"}
+	defer func() { G_config.BotFilter = DefaultConfig().BotFilter }()
+	AddRouters(gin.New(), MockNewRedisClient(), logger)
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if fields["component"] != "bot_filter" || fields["level"] != "warning" {
+		t.Errorf("unexpected log line %s", buf.String())
+	}
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+)
+
+const (
+	LOG_ROTATION_BUILTIN  = "builtin"
+	LOG_ROTATION_EXTERNAL = "external"
+)
+
+// logWriter is a log file which could be reopened on SIGHUP
+type logWriter interface {
+	io.Writer
+	Reopen() error
+}
+
+// log files to reopen on SIGHUP
+var (
+	logWritersMu sync.Mutex
+	logWriters   []logWriter
+)
+
+// newLogWriter opens the log file at fileName, rotated by itself or by
+// external tools. It fails if the directory of logs is not writable
+func newLogWriter(fileName string, config LogConfig) (logWriter, error) {
+	if err := os.MkdirAll(path.Dir(fileName), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("create log directory[%s] failed. err[%v]", path.Dir(fileName), err)
+	}
+	// rotatelogs opens files on the first write, find out now
+	probe, err := os.CreateTemp(path.Dir(fileName), ".probe")
+	if err != nil {
+		return nil, fmt.Errorf("log directory[%s] is not writable. err[%v]", path.Dir(fileName), err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	var writer logWriter
+	if config.Rotation == LOG_ROTATION_EXTERNAL {
+		writer, err = newReopenFile(fileName)
+	} else {
+		writer, err = newRotateFile(fileName, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logWritersMu.Lock()
+	logWriters = append(logWriters, writer)
+	logWritersMu.Unlock()
+	return writer, nil
+}
+
+// ReopenLogs reopens all log files, after logrotate moved them
+func ReopenLogs() {
+	logWritersMu.Lock()
+	defer logWritersMu.Unlock()
+	for _, writer := range logWriters {
+		if err := writer.Reopen(); err != nil {
+			fmt.Fprintln(os.Stderr, "reopen log failed. err:", err)
+		}
+	}
+}
+
+func reopenLogsOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		ReopenLogs()
+	}
+}
+
+// reopenFile writes to fileName, leaving rotation to external tools
+type reopenFile struct {
+	mu       sync.Mutex
+	fileName string
+	f        *os.File
+}
+
+func newReopenFile(fileName string) (*reopenFile, error) {
+	writer := &reopenFile{fileName: fileName}
+	if err := writer.Reopen(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *reopenFile) Write(p []byte) (int, error) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	return writer.f.Write(p)
+}
+
+func (writer *reopenFile) Reopen() error {
+	f, err := os.OpenFile(writer.fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open log file[%s] failed. err[%v]", writer.fileName, err)
+	}
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if writer.f != nil {
+		writer.f.Close()
+	}
+	writer.f = f
+	return nil
+}
+
+// rotateFile writes to files like `counter-service.log.202212260000`
+// with fileName linked to the current one, rotated by time and size
+type rotateFile struct {
+	*rotatelogs.RotateLogs
+}
+
+// oldLogName is out of the glob `<fileName>.*` of expired files
+func oldLogName(fileName string) string {
+	ext := path.Ext(fileName)
+	if ext == "" {
+		return fileName + "-old"
+	}
+	return strings.TrimSuffix(fileName, ext) + ".old" + ext
+}
+
+func newRotateFile(fileName string, config LogConfig) (*rotateFile, error) {
+	// a plain file left by older versions would be replaced by the link,
+	// keep it where max_age doesn't remove it
+	if info, err := os.Lstat(fileName); err == nil && info.Mode().IsRegular() {
+		if err = os.Rename(fileName, oldLogName(fileName)); err != nil {
+			return nil, fmt.Errorf("move log file[%s] failed. err[%v]", fileName, err)
+		}
+		fmt.Fprintf(os.Stderr, "log file[%s] moved to [%s]\n", fileName, oldLogName(fileName))
+	}
+	options := []rotatelogs.Option{
+		rotatelogs.WithLinkName(fileName),
+		rotatelogs.WithMaxAge(config.MaxAge),
+		rotatelogs.WithRotationTime(config.RotationTime),
+	}
+	if config.RotationSize > 0 {
+		options = append(options, rotatelogs.WithRotationSize(config.RotationSize))
+	}
+	if config.Compress {
+		options = append(options, rotatelogs.WithHandler(rotatelogs.HandlerFunc(compressRotated)))
+	}
+	// the glob of expired files, `<fileName>.*`, covers the compressed ones
+	rl, err := rotatelogs.New(fileName+".%Y%m%d%H%M", options...)
+	if err != nil {
+		return nil, fmt.Errorf("create rotatelogs of [%s] failed. err[%v]", fileName, err)
+	}
+	return &rotateFile{RotateLogs: rl}, nil
+}
+
+// Reopen does nothing, files are rotated by time and size only and
+// SIGHUP is for external rotation
+func (writer *rotateFile) Reopen() error {
+	return nil
+}
+
+func compressRotated(event rotatelogs.Event) {
+	rotated, ok := event.(*rotatelogs.FileRotatedEvent)
+	if !ok || rotated.PreviousFile() == "" || strings.HasSuffix(rotated.PreviousFile(), ".gz") {
+		return
+	}
+	if err := compressFile(rotated.PreviousFile()); err != nil {
+		fmt.Fprintln(os.Stderr, "compress log failed. err:", err)
+	}
+}
+
+// compressFile replaces fileName with fileName.gz
+func compressFile(fileName string) error {
+	src, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(fileName + ".gz.tmp")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(fileName+".gz.tmp", fileName+".gz")
+	}
+	if err != nil {
+		os.Remove(fileName + ".gz.tmp")
+		return fmt.Errorf("compress file[%s] failed. err[%v]", fileName, err)
+	}
+	return os.Remove(fileName)
+}
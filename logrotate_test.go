@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateLog(t *testing.T) {
+	config := DefaultConfig().Log
+	config.Path = t.TempDir()
+	config.RotationSize = 100
+	config.Compress = true
+	logger := NewLogger(config)
+	for i := 0; i < 10; i++ {
+		logger.Info("hello world")
+	}
+
+	fileName := path.Join(config.Path, config.Name)
+	content, err := os.ReadFile(fileName)
+	if err != nil || !strings.Contains(string(content), "hello world") {
+		t.Fail()
+	}
+	// rotated files are compressed in background
+	var compressed []string
+	for i := 0; i < 100 && len(compressed) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		compressed, _ = filepath.Glob(fileName + ".*.gz")
+	}
+	if len(compressed) == 0 {
+		t.Error("rotated files should be compressed")
+	}
+}
+
+func TestReopenLog(t *testing.T) {
+	config := DefaultConfig().Log
+	config.Path = t.TempDir()
+	config.Rotation = LOG_ROTATION_EXTERNAL
+	// leave alone the logs of other tests
+	logWritersMu.Lock()
+	logWriters = nil
+	logWritersMu.Unlock()
+	logger := NewLogger(config)
+	fileName := path.Join(config.Path, config.Name)
+
+	logger.Info("before rotation")
+	// like logrotate
+	if err := os.Rename(fileName, fileName+".1"); err != nil {
+		t.Fatal(err)
+	}
+	ReopenLogs()
+	logger.Info("after rotation")
+
+	before, _ := os.ReadFile(fileName + ".1")
+	after, _ := os.ReadFile(fileName)
+	if !strings.Contains(string(before), "before rotation") || strings.Contains(string(before), "after rotation") ||
+		!strings.Contains(string(after), "after rotation") {
+		t.Fail()
+	}
+}
+
+func TestLogFallback(t *testing.T) {
+	// a file where the directory should be
+	notDir := path.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig().Log
+	config.Path = path.Join(notDir, "log")
+	logger := NewLogger(config)
+	if logger.Out != os.Stdout {
+		t.Fail()
+	}
+	logger.Info("hello stdout")
+}
+
+func TestRotateLogKeepsOld(t *testing.T) {
+	config := DefaultConfig().Log
+	config.Path = t.TempDir()
+	config.MaxAge = time.Millisecond
+	fileName := path.Join(config.Path, config.Name)
+	if err := os.WriteFile(fileName, []byte("older version"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logger := NewLogger(config)
+	logger.Info("hello world")
+	// builtin rotation doesn't rotate on SIGHUP
+	ReopenLogs()
+	logger.Info("hello again")
+
+	content, err := os.ReadFile(oldLogName(fileName))
+	if err != nil || string(content) != "older version" {
+		t.Fail()
+	}
+	if matches, _ := filepath.Glob(fileName + ".*"); len(matches) != 1 {
+		t.Errorf("there should be one generation, got %v", matches)
+	}
+}
@@ -9,59 +9,82 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
-	"github.com/juju/ratelimit"
 )
 
-func CORSMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+func Init(config *Config) *gin.Engine {
+	G_config = config
+	gin.SetMode(config.Server.Mode)
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
+	// without the logger of gin, requests only go to the access log
+	r := gin.New()
+	r.Use(gin.Recovery())
+	logger := NewLogger(config.Log)
+	logger.WithField("component", "main").Infof("effective config:\n%s", config)
 
-		c.Next()
+	provider, err := InitTracing(config.Tracing)
+	if err != nil {
+		panic(err)
 	}
-}
+	G_tracerProvider = provider
+	r.Use(RequestIDMiddleware())
+	r.Use(TracingMiddleware())
+	r.Use(LoggerMiddleware(logger, NewAccessLogger(config.AccessLog, config.Log)))
 
-func RateLimitMiddleware(fillInterval time.Duration, cap, quantum int64) gin.HandlerFunc {
-	bucket := ratelimit.NewBucketWithQuantum(fillInterval, cap, quantum)
-	return func(c *gin.Context) {
-		if bucket.TakeAvailable(1) < 1 {
-			c.AbortWithStatus(429)
-			return
-		}
-		c.Next()
+	db, err := NewStore(config.Store, logger)
+	if err != nil {
+		panic(err)
 	}
-}
-
-func Init() *gin.Engine {
-	// gin.SetMode(gin.ReleaseMode)
-
-	r := gin.Default()
-	logger := NewLogger()
-	r.Use(LoggerMiddleware(logger))
+	if provider != nil {
+		db = NewTracedStore(db)
+	}
+	// before the rate limiter, so pages could read its 429s
 	r.Use(CORSMiddleware())
-	r.Use(RateLimitMiddleware(1*time.Second, 100, 50))
-
-	AddRouters(r, logger)
+	r.Use(RateLimitMiddleware(NewRateLimiter(config.RateLimit, db, logger)))
+	AddRouters(r, db, logger)
 
 	return r
 }
 
-func main() {
-	r := Init()
+// close the store before exit, so the memory store could flush its snapshot,
+// and flush the spans not exported yet
+func closeStoreOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	if G_tracerProvider != nil {
+		if err := G_tracerProvider.Shutdown(context.Background()); err != nil {
+			G_logger.WithField("component", "main").Error(err)
+		}
+	}
+	if G_db != nil {
+		if err := G_db.Close(); err != nil {
+			G_logger.WithField("component", "main").Error(err)
+		}
+	}
+	os.Exit(0)
+}
 
-	if environment := os.Getenv("GIN_MODE"); environment == "release" {
-		r.Run(":8000")
-	} else {
-		r.Run(SERVER_PORT)
+func main() {
+	config, err := LoadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Println("load config failed. err:", err)
+		os.Exit(1)
 	}
+
+	r := Init(config)
+	go closeStoreOnSignal()
+	go reopenLogsOnSignal()
+
+	r.Run(config.Server.Port)
 }
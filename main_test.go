@@ -2,13 +2,36 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestMain(t *testing.T) {
-	r := Init()
+	r := Init(DefaultConfig())
 	if r == nil {
 		fmt.Println("Init failed")
 		t.Fail()
 	}
 }
+
+func TestInitCORSOnRateLimit(t *testing.T) {
+	config := DefaultConfig()
+	config.Store.RedisUrl = MockRedisUrl()
+	config.RateLimit.PerIp = RateLimitRule{Limit: 1, Window: time.Minute}
+	r := Init(config)
+	defer func() {
+		G_config = DefaultConfig()
+		CleanLog()
+	}()
+
+	// pages read the rejection and the headers of rate limit
+	headers := map[string]string{"Origin": "https://example.com"}
+	MockRequest(r, "GET", "/pv/get?namespace=corsratetest&key=a", headers, "")
+	w, _ := MockRequest(r, "GET", "/pv/get?namespace=corsratetest&key=a", headers, "")
+	if w.Code != 429 || w.Header().Get("Access-Control-Allow-Origin") != "*" ||
+		!strings.Contains(w.Header().Get("Access-Control-Expose-Headers"), "Retry-After") {
+		fmt.Println(w.Code, w.Header())
+		t.Fail()
+	}
+}
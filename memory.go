@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryStore keeps everything in process, and snapshots to a local file
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*storeEntry
+	logger  *logrus.Logger
+
+	keyTTL       time.Duration
+	snapshotPath string
+	stop         chan struct{}
+	stopOnce     sync.Once
+	done         chan struct{}
+
+	// for test
+	now func() time.Time
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore(snapshotPath string, snapshotInterval, keyTTL time.Duration, logger *logrus.Logger) *MemoryStore {
+	db := &MemoryStore{
+		entries:      make(map[string]*storeEntry),
+		logger:       logger,
+		keyTTL:       keyTTL,
+		snapshotPath: snapshotPath,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+		now:          time.Now,
+	}
+	if snapshotPath != "" {
+		if err := db.Load(); err != nil {
+			logger.WithField("component", "memory").Info("load snapshot failed: ", err)
+			return nil
+		}
+	}
+	go db.loop(snapshotInterval)
+	return db
+}
+
+func (db *MemoryStore) loop(interval time.Duration) {
+	defer close(db.done)
+	if interval <= 0 {
+		<-db.stop
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.removeExpired(); err != nil {
+				db.logger.WithField("component", "memory").Warn(err)
+			}
+			if err := db.Snapshot(); err != nil {
+				db.logger.WithField("component", "memory").Warn(err)
+			}
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+func (db *MemoryStore) removeExpired() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().removeExpired()
+}
+
+// Snapshot writes all entries to a temporary file, then renames it
+func (db *MemoryStore) Snapshot() error {
+	if db.snapshotPath == "" {
+		return nil
+	}
+	var b bytes.Buffer
+	db.mu.Lock()
+	err := gob.NewEncoder(&b).Encode(db.entries)
+	db.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encode snapshot failed. err[%v]", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(db.snapshotPath), os.ModePerm); err != nil {
+		return fmt.Errorf("create snapshot directory failed. err[%v]", err)
+	}
+	// synced before the rename, so a crash never leaves a truncated snapshot
+	tmpPath := db.snapshotPath + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open snapshot[%s] failed. err[%v]", tmpPath, err)
+	}
+	_, err = f.Write(b.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write snapshot[%s] failed. err[%v]", tmpPath, err)
+	}
+	if err = os.Rename(tmpPath, db.snapshotPath); err != nil {
+		return fmt.Errorf("rename snapshot[%s] failed. err[%v]", tmpPath, err)
+	}
+	return nil
+}
+
+// Load reads entries from the snapshot file, a missing file is not an error
+func (db *MemoryStore) Load() error {
+	f, err := os.Open(db.snapshotPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open snapshot[%s] failed. err[%v]", db.snapshotPath, err)
+	}
+	defer f.Close()
+
+	entries := make(map[string]*storeEntry)
+	if err = gob.NewDecoder(f).Decode(&entries); err != nil {
+		return fmt.Errorf("decode snapshot[%s] failed. err[%v]", db.snapshotPath, err)
+	}
+	db.mu.Lock()
+	db.entries = entries
+	db.mu.Unlock()
+	return nil
+}
+
+// Close stops the background loop and writes the last snapshot,
+// it could be called more than once
+func (db *MemoryStore) Close() error {
+	db.stopOnce.Do(func() { close(db.stop) })
+	<-db.done
+	return db.Snapshot()
+}
+
+// memoryKeyspace is a plain map, protected by the lock of MemoryStore
+type memoryKeyspace map[string]*storeEntry
+
+func (ks memoryKeyspace) load(key string) (*storeEntry, error) {
+	return ks[key], nil
+}
+
+func (ks memoryKeyspace) save(key string, entry *storeEntry) error {
+	ks[key] = entry
+	return nil
+}
+
+func (ks memoryKeyspace) remove(key string) error {
+	delete(ks, key)
+	return nil
+}
+
+func (ks memoryKeyspace) scan(prefix string, fn func(key string, entry *storeEntry) error) error {
+	for key, entry := range ks {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if err := fn(key, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commands must be called with the lock held
+func (db *MemoryStore) commands() *commands {
+	return &commands{ks: memoryKeyspace(db.entries), now: db.now(), ttl: db.keyTTL}
+}
+
+func (db *MemoryStore) RefreshExpire(ctx context.Context, key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().refreshExpire(key)
+}
+
+func (db *MemoryStore) Expire(ctx context.Context, ttl time.Duration, keys ...string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().expire(ttl, keys...)
+}
+
+func (db *MemoryStore) Set(ctx context.Context, key string, value interface{}, use_ttl bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().set(key, value, use_ttl)
+}
+
+func (db *MemoryStore) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().setNX(key, value, ttl)
+}
+
+func (db *MemoryStore) Get(ctx context.Context, key string) (*RedisResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().get(key)
+}
+
+func (db *MemoryStore) BatchGet(ctx context.Context, keys ...string) ([]RedisResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().batchGet(keys...)
+}
+
+func (db *MemoryStore) BatchPeek(ctx context.Context, keys ...string) ([]RedisResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().batchPeek(keys...)
+}
+
+func (db *MemoryStore) Incr(ctx context.Context, key string) (*RedisResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().incr(key)
+}
+
+func (db *MemoryStore) IncrBy(ctx context.Context, key string, by int64, floorAtZero bool) (*RedisResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	cmd := db.commands()
+	return cmd.incrBy(key, by, cmd.ttl, floorAtZero)
+}
+
+func (db *MemoryStore) BatchIncr(ctx context.Context, keys []string, ttls []time.Duration) ([]RedisResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().batchIncr(keys, ttls)
+}
+
+func (db *MemoryStore) BatchIncrBy(ctx context.Context, keys []string, bys []int64) ([]RedisResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().batchIncrBy(keys, bys)
+}
+
+func (db *MemoryStore) Delete(ctx context.Context, keys ...string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().delete(keys...)
+}
+
+func (db *MemoryStore) GetPrefixMatchKeys(ctx context.Context, pattern string) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().keys(pattern)
+}
+
+func (db *MemoryStore) DeleteMatchKeys(ctx context.Context, pattern string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().deleteMatch(pattern)
+}
+
+func (db *MemoryStore) Rename(ctx context.Context, keys []string, newKeys []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().rename(keys, newKeys)
+}
+
+func (db *MemoryStore) PfAdd(ctx context.Context, key string, elements ...interface{}) (*RedisResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().pfAdd(key, elements...)
+}
+
+func (db *MemoryStore) PfCount(ctx context.Context, key string) (*RedisResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().pfCount(key)
+}
+
+func (db *MemoryStore) BatchPfCount(ctx context.Context, keys ...string) ([]RedisResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().batchPfCount(keys...)
+}
+
+func (db *MemoryStore) BatchZIncrBy(ctx context.Context, keys []string, ttls []time.Duration, member string, by int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().batchZIncrBy(keys, ttls, member, by)
+}
+
+func (db *MemoryStore) ZAdd(ctx context.Context, key string, member string, score int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().zAdd(key, member, score)
+}
+
+func (db *MemoryStore) ZRem(ctx context.Context, keys []string, member string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().zRem(keys, member)
+}
+
+func (db *MemoryStore) ZTop(ctx context.Context, keys []string, limit int) ([]RedisResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commands().zTop(keys, limit)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func MockNewMemoryStore(t *testing.T) *MemoryStore {
+	logger := MockNewLogger()
+
+	db := NewMemoryStore(filepath.Join(t.TempDir(), "counter.snapshot"), 0, DefaultConfig().Store.KeyTTL, logger)
+	return db
+}
+
+func TestMemoryGetAndSet(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	ret, err := db.Get(ctx, "hello")
+	if ret != nil || err == nil {
+		t.Fail()
+	}
+	err = db.Set(ctx, "test", "yes", true)
+	if err != nil {
+		t.Fail()
+	}
+	ret, err = db.Get(ctx, "test")
+	if err != nil || ret.value != "yes" {
+		fmt.Println(err)
+		t.Fail()
+	}
+}
+
+func TestMemorySetNX(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	now := time.Now()
+	db.now = func() time.Time { return now }
+	if ok, err := db.SetNX(ctx, "dedup", 1, time.Minute); !ok || err != nil {
+		t.Fail()
+	}
+	if ok, err := db.SetNX(ctx, "dedup", 2, time.Minute); ok || err != nil {
+		t.Fail()
+	}
+	now = now.Add(time.Minute)
+	if ok, err := db.SetNX(ctx, "dedup", 3, time.Minute); !ok || err != nil {
+		t.Fail()
+	}
+}
+
+func TestMemoryIncr(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	_ = db.Set(ctx, "counter", 0, true)
+	ret, err := db.Incr(ctx, "counter")
+	if err != nil || ret.value.(int64) != 1 {
+		fmt.Println(err)
+		t.Fail()
+	}
+	ret, err = db.Incr(ctx, "new")
+	if err != nil || ret.value.(int64) != 1 {
+		t.Fail()
+	}
+
+	// failure case
+	_ = db.Set(ctx, "test", "yes", true)
+	ret, err = db.Incr(ctx, "test")
+	if ret != nil || err == nil {
+		t.Fail()
+	}
+}
+
+func TestMemoryIncrBy(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	ret, err := db.IncrBy(ctx, "counter", 5, false)
+	if err != nil || ret.value.(int64) != 5 {
+		fmt.Println(err)
+		t.Fail()
+	}
+	ret, err = db.IncrBy(ctx, "counter", -7, false)
+	if err != nil || ret.value.(int64) != -2 {
+		t.Fail()
+	}
+	ret, err = db.IncrBy(ctx, "counter", -1, true)
+	if err != nil || ret.value.(int64) != 0 {
+		t.Fail()
+	}
+	if ret, err := db.Get(ctx, "counter"); err != nil || ret.value != "0" {
+		t.Fail()
+	}
+}
+
+func TestMemoryBatchGetAndDelete(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	_ = db.Set(ctx, "a", 1, true)
+	_ = db.Set(ctx, "b", 2, true)
+	results, err := db.BatchGet(ctx, "a", "b", "c")
+	if err != nil || len(results) != 3 || results[1].value != "2" || results[2].value != "" {
+		fmt.Println(results)
+		t.Fail()
+	}
+
+	cnt, err := db.Delete(ctx, "a", "b", "c")
+	if err != nil || cnt != 2 {
+		t.Fail()
+	}
+	if ret, err := db.Get(ctx, "a"); ret != nil || err == nil {
+		t.Fail()
+	}
+}
+
+func TestMemoryExpire(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	now := time.Now()
+	db.now = func() time.Time { return now }
+	_ = db.Set(ctx, "ttl", "yes", true)
+	_ = db.Set(ctx, "no-ttl", "yes", false)
+
+	now = now.Add(DefaultConfig().Store.KeyTTL - time.Second)
+	// refresh expire
+	if _, err := db.Get(ctx, "ttl"); err != nil {
+		t.Fail()
+	}
+	now = now.Add(DefaultConfig().Store.KeyTTL - time.Second)
+	if _, err := db.Get(ctx, "ttl"); err != nil {
+		t.Fail()
+	}
+	now = now.Add(DefaultConfig().Store.KeyTTL)
+	if _, err := db.Get(ctx, "ttl"); err == nil {
+		t.Fail()
+	}
+	keys, _ := db.GetPrefixMatchKeys(ctx, "*")
+	if len(keys) != 1 || keys[0] != "no-ttl" {
+		fmt.Println(keys)
+		t.Fail()
+	}
+
+	// expire with the given ttl, missing keys are skipped
+	if err := db.Expire(ctx, time.Minute, "no-ttl", "missing"); err != nil {
+		t.Fail()
+	}
+	now = now.Add(time.Minute)
+	if keys, _ = db.GetPrefixMatchKeys(ctx, "*"); len(keys) != 0 {
+		fmt.Println(keys)
+		t.Fail()
+	}
+}
+
+func TestMemoryGetPrefixMatchKeys(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	for i := 0; i < 33; i++ {
+		_ = db.Set(ctx, fmt.Sprintf("key%d", i), "value", true)
+	}
+	_ = db.Set(ctx, "namespace@test", "value", false)
+	keys, err := db.GetPrefixMatchKeys(ctx, "key*")
+	if len(keys) != 33 || err != nil {
+		t.Fail()
+	}
+	keys, _ = db.GetPrefixMatchKeys(ctx, "key?")
+	if len(keys) != 10 {
+		t.Fail()
+	}
+	keys, _ = db.GetPrefixMatchKeys(ctx, "key[1-2]?")
+	if len(keys) != 20 {
+		t.Fail()
+	}
+}
+
+func TestMemoryPfAddAndCount(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	if ret, err := db.PfCount(ctx, "hll"); ret != nil || err == nil {
+		t.Fail()
+	}
+	var ret *RedisResult
+	for _, visitor := range []string{"a", "b", "a"} {
+		ret, _ = db.PfAdd(ctx, "hll", visitor)
+	}
+	if ret.value.(int64) != 2 {
+		t.Fail()
+	}
+	results, err := db.BatchPfCount(ctx, "hll", "none")
+	if err != nil || results[0].value.(int64) != 2 || results[1].value.(int64) != 0 {
+		t.Fail()
+	}
+
+	// wrong type
+	_ = db.Set(ctx, "test", "yes", true)
+	if _, err := db.PfAdd(ctx, "test", "a"); err == nil {
+		t.Fail()
+	}
+}
+
+func TestMemorySnapshot(t *testing.T) {
+	logger := MockNewLogger()
+	defer CleanLog()
+
+	snapshotPath := filepath.Join(t.TempDir(), "data", "counter.snapshot")
+	db := NewMemoryStore(snapshotPath, 0, DefaultConfig().Store.KeyTTL, logger)
+	_ = db.Set(ctx, "namespace@test", string([]byte{0xff, 0x00}), false)
+	_, _ = db.Incr(ctx, "key@test@test")
+	_, _ = db.PfAdd(ctx, "uv@test@test", "a", "b")
+	if err := db.Close(); err != nil {
+		t.Error(err)
+	}
+	// closed by the signal handler and others
+	if err := db.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(snapshotPath + ".tmp"); !os.IsNotExist(err) {
+		t.Fail()
+	}
+
+	db = NewMemoryStore(snapshotPath, 0, DefaultConfig().Store.KeyTTL, logger)
+	defer db.Close()
+	ret, err := db.Get(ctx, "namespace@test")
+	if err != nil || ret.value != string([]byte{0xff, 0x00}) {
+		t.Fail()
+	}
+	ret, err = db.Incr(ctx, "key@test@test")
+	if err != nil || ret.value.(int64) != 2 {
+		t.Fail()
+	}
+	ret, err = db.PfCount(ctx, "uv@test@test")
+	if err != nil || ret.value.(int64) != 2 {
+		t.Fail()
+	}
+}
+
+func TestMemoryBatchIncrAndPeek(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	now := time.Now()
+	db.now = func() time.Time { return now }
+	results, err := db.BatchIncr(ctx, []string{"hour", "day"}, []time.Duration{time.Hour, 24 * time.Hour})
+	if err != nil || len(results) != 2 || results[1].value.(int64) != 1 {
+		t.Fail()
+	}
+
+	now = now.Add(2 * time.Hour)
+	results, err = db.BatchPeek(ctx, "hour", "day")
+	if err != nil || results[0].value != "" || results[1].value != "1" {
+		fmt.Println(results)
+		t.Fail()
+	}
+}
+
+func TestMemoryBatchIncrBy(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	results, err := db.BatchIncrBy(ctx, []string{"a", "b", "a"}, []int64{1, 5, 10})
+	if err != nil || len(results) != 3 || results[1].value.(int64) != 5 || results[2].value.(int64) != 11 {
+		fmt.Println(err, results)
+		t.Fail()
+	}
+
+	// all or nothing
+	_ = db.Set(ctx, "test", "yes", true)
+	if _, err := db.BatchIncrBy(ctx, []string{"a", "test"}, []int64{1, 1}); err == nil {
+		t.Fail()
+	}
+	if ret, _ := db.Get(ctx, "a"); ret.value != "11" {
+		t.Fail()
+	}
+}
+
+func TestMemoryZTop(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	ttls := []time.Duration{time.Hour, time.Hour}
+	_ = db.BatchZIncrBy(ctx, []string{"z1", "z2"}, ttls, "a", 1)
+	_ = db.BatchZIncrBy(ctx, []string{"z2"}, ttls, "b", 3)
+	_ = db.ZAdd(ctx, "z1", "c", 5)
+
+	results, err := db.ZTop(ctx, []string{"z1", "z2"}, 2)
+	if err != nil || len(results) != 2 || results[0].key != "c" || results[1].key != "b" {
+		fmt.Println(err, results)
+		t.Fail()
+	}
+
+	_ = db.ZRem(ctx, []string{"z1", "z2"}, "a")
+	results, _ = db.ZTop(ctx, []string{"z1", "z2"}, 10)
+	if len(results) != 2 {
+		t.Fail()
+	}
+
+	// wrong type
+	_ = db.Set(ctx, "test", "yes", true)
+	if err := db.ZAdd(ctx, "test", "a", 1); err == nil {
+		t.Fail()
+	}
+}
+
+func TestMemoryDeleteMatchKeysAndRename(t *testing.T) {
+	db := MockNewMemoryStore(t)
+	defer CleanLog()
+
+	for i := 0; i < 3; i++ {
+		_ = db.Set(ctx, fmt.Sprintf("key@a@%d", i), i, true)
+	}
+	_ = db.Set(ctx, "key@b@0", 0, true)
+
+	// nothing changes if any new key exists
+	if err := db.Rename(ctx, []string{"key@a@0", "key@a@1"}, []string{"key@c@0", "key@b@0"}); err == nil {
+		t.Fail()
+	}
+	if ret, _ := db.Get(ctx, "key@a@0"); ret == nil {
+		t.Fail()
+	}
+	// missing keys are skipped
+	if err := db.Rename(ctx, []string{"key@a@0", "key@a@9"}, []string{"key@c@0", "key@c@9"}); err != nil {
+		t.Fail()
+	}
+	if ret, _ := db.Get(ctx, "key@c@0"); ret == nil || ret.value != "0" {
+		t.Fail()
+	}
+	if ret, _ := db.Get(ctx, "key@a@0"); ret != nil {
+		t.Fail()
+	}
+
+	cnt, err := db.DeleteMatchKeys(ctx, "key@a@*")
+	if cnt != 2 || err != nil {
+		t.Fail()
+	}
+	keys, _ := db.GetPrefixMatchKeys(ctx, "key@*")
+	if len(keys) != 2 {
+		fmt.Println(keys)
+		t.Fail()
+	}
+}
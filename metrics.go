@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	METRICS_NAMESPACE = "counter"
+	// label of namespaces not in metrics.namespaces
+	METRICS_OTHER_NAMESPACE = "_other"
+	// label of requests not matching any route
+	METRICS_UNMATCHED_ROUTE = "unmatched"
+)
+
+// metrics live in their own registry rather than the global one,
+// so nothing registered by dependencies shows up by accident
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of http requests by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	redisCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Latency of redis commands, a pipeline counts as one.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+
+	redisCommandErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "redis_command_errors_total",
+		Help:      "Failed redis commands, missing keys are not errors.",
+	}, []string{"command"})
+
+	rateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the rate limiter by layer.",
+	}, []string{"layer"})
+
+	namespaceIncrements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "namespace_increments_total",
+		Help:      "Counted views by namespace and kind, namespaces beyond the cap are _other.",
+	}, []string{"namespace", "kind"})
+
+	methodCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "method_calls_total",
+		Help:      "Calls of api methods, the same as call@<method> in the store.",
+	}, []string{"method"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		requestDuration,
+		redisCommandDuration,
+		redisCommandErrors,
+		rateLimitRejections,
+		namespaceIncrements,
+		methodCalls,
+		redisPoolCollector{},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// observeRequest records the latency measured by LoggerMiddleware
+func observeRequest(c *gin.Context, latency time.Duration) {
+	route := c.FullPath()
+	if route == "" {
+		route = METRICS_UNMATCHED_ROUTE
+	}
+	requestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(latency.Seconds())
+}
+
+// namespaceLabels labels only the namespaces configured, others share one
+// label, so namespaces created by anyone can't blow up or take the series
+type namespaceLabels struct {
+	mu      sync.RWMutex
+	allowed map[string]bool
+}
+
+var G_namespaceLabels = newNamespaceLabels(nil)
+
+func newNamespaceLabels(namespaces []string) *namespaceLabels {
+	labels := &namespaceLabels{allowed: make(map[string]bool)}
+	labels.set(namespaces)
+	return labels
+}
+
+func (labels *namespaceLabels) set(namespaces []string) {
+	allowed := make(map[string]bool)
+	for _, namespace := range namespaces {
+		allowed[namespace] = true
+	}
+	labels.mu.Lock()
+	labels.allowed = allowed
+	labels.mu.Unlock()
+}
+
+func (labels *namespaceLabels) label(namespace string) string {
+	labels.mu.RLock()
+	defer labels.mu.RUnlock()
+	if labels.allowed[namespace] {
+		return namespace
+	}
+	return METRICS_OTHER_NAMESPACE
+}
+
+// countIncrement records a counted view of kind pv or uv, a private
+// namespace is never a label so scrapes can't list it
+func countIncrement(settings *namespaceSettings, namespace, kind string) {
+	label := METRICS_OTHER_NAMESPACE
+	if settings.Visibility == VISIBILITY_PUBLIC {
+		label = G_namespaceLabels.label(namespace)
+	}
+	namespaceIncrements.WithLabelValues(label, kind).Inc()
+}
+
+type redisStartKey struct{}
+
+// redisMetricsHook measures every command and pipeline of the redis client
+type redisMetricsHook struct{}
+
+var _ redis.Hook = redisMetricsHook{}
+
+func (redisMetricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisMetricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeRedis(ctx, cmd.Name(), cmd.Err())
+	return nil
+}
+
+func (redisMetricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisMetricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && !errors.Is(cmdErr, redis.Nil) {
+			err = cmdErr
+			break
+		}
+	}
+	observeRedis(ctx, "pipeline", err)
+	return nil
+}
+
+func observeRedis(ctx context.Context, command string, err error) {
+	command = strings.ToLower(command)
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		redisCommandDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	}
+	if err != nil && !errors.Is(err, redis.Nil) {
+		redisCommandErrors.WithLabelValues(command).Inc()
+	}
+}
+
+var (
+	redisPoolHits = prometheus.NewDesc(METRICS_NAMESPACE+"_redis_pool_hits_total",
+		"Times a free connection was found in the pool.", nil, nil)
+	redisPoolMisses = prometheus.NewDesc(METRICS_NAMESPACE+"_redis_pool_misses_total",
+		"Times a free connection was not found in the pool.", nil, nil)
+	redisPoolTimeouts = prometheus.NewDesc(METRICS_NAMESPACE+"_redis_pool_timeouts_total",
+		"Times a wait for a connection timed out.", nil, nil)
+	redisPoolTotalConns = prometheus.NewDesc(METRICS_NAMESPACE+"_redis_pool_total_conns",
+		"Connections in the pool.", nil, nil)
+	redisPoolIdleConns = prometheus.NewDesc(METRICS_NAMESPACE+"_redis_pool_idle_conns",
+		"Idle connections in the pool.", nil, nil)
+	redisPoolStaleConns = prometheus.NewDesc(METRICS_NAMESPACE+"_redis_pool_stale_conns_total",
+		"Stale connections removed from the pool.", nil, nil)
+)
+
+// redisPoolCollector reads the pool stats of the store on scrape,
+// nothing is collected with other backends
+type redisPoolCollector struct{}
+
+func (redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- redisPoolHits
+	ch <- redisPoolMisses
+	ch <- redisPoolTimeouts
+	ch <- redisPoolTotalConns
+	ch <- redisPoolIdleConns
+	ch <- redisPoolStaleConns
+}
+
+func (redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	store := G_db
+	if traced, ok := store.(*tracedStore); ok {
+		store = traced.Store
+	}
+	db, ok := store.(*RedisStore)
+	if !ok || db == nil {
+		return
+	}
+	stats := db.redisClient.PoolStats()
+	ch <- prometheus.MustNewConstMetric(redisPoolHits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(redisPoolMisses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(redisPoolTimeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(redisPoolTotalConns, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(redisPoolIdleConns, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(redisPoolStaleConns, prometheus.CounterValue, float64(stats.StaleConns))
+}
+
+// AddMetricsRouter exposes the metrics in prometheus format,
+// behind the bearer token if configured
+func AddMetricsRouter(r *gin.Engine, config MetricsConfig) {
+	if !config.Enabled {
+		return
+	}
+	G_namespaceLabels.set(config.Namespaces)
+	handler := gin.WrapH(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	r.GET(config.Path, func(c *gin.Context) {
+		if config.Token != "" && subtle.ConstantTimeCompare([]byte(getBearerToken(c)), []byte(config.Token)) != 1 {
+			errMsg := ErrorMessage{
+				Code:   4002,
+				ErrMsg: "authentication failed",
+			}
+			c.JSON(http.StatusBadRequest, errMsg)
+			return
+		}
+		handler(c)
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNamespaceLabels(t *testing.T) {
+	labels := newNamespaceLabels([]string{"a", "b"})
+	// not first come, first served
+	for namespace, label := range map[string]string{"c": METRICS_OTHER_NAMESPACE, "d": METRICS_OTHER_NAMESPACE, "a": "a", "b": "b"} {
+		if labels.label(namespace) != label {
+			t.Errorf("label of %s should be %s", namespace, label)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	G_config.Metrics.Enabled = true
+	G_config.Metrics.Token = "scrape"
+	G_config.Metrics.Namespaces = []string{"metricstest", "metricsprivatetest"}
+	router := MockRouters()
+	defer CleanLog()
+
+	defer func() {
+		G_config.Metrics = DefaultConfig().Metrics
+		for _, namespace := range []string{"metricstest", "metricsprivatetest"} {
+			for _, pattern := range namespaceKeyPatterns(namespace) {
+				G_db.DeleteMatchKeys(ctx, pattern)
+			}
+		}
+		G_db.Delete(ctx, "call@create_pv", "call@increment_pv", "call@increment_uv", "call@update_settings")
+	}()
+
+	MockRequest(router, "POST", "/pv/create?namespace=metricstest&secret=world", nil, "")
+	MockRequest(router, "POST", "/pv/increment?namespace=metricstest&key=page", nil, "")
+	MockRequest(router, "POST", "/uv/increment?namespace=metricstest&key=page", nil, "")
+	MockRequest(router, "GET", "/not/exist", nil, "")
+	MockRequest(router, "POST", "/pv/create?namespace=metricsprivatetest&secret=world", nil, "")
+	MockRequest(router, "PUT", "/pv/namespace?namespace=metricsprivatetest&secret=world", nil, `{"visibility":"private"}`)
+	MockRequest(router, "POST", "/pv/increment?namespace=metricsprivatetest&key=page", nil, "")
+
+	for _, token := range []string{"", "hello"} {
+		if w, _ := MockRequest(router, "GET", "/metrics", bearer(token), ""); w.Code != 400 {
+			t.Errorf("scrape with token %s should fail", token)
+		}
+	}
+	w, _ := MockRequest(router, "GET", "/metrics", bearer("scrape"), "")
+	if w.Code != 200 {
+		t.Fail()
+	}
+	body := w.Body.String()
+	if strings.Contains(body, "metricsprivatetest") {
+		t.Error("private namespaces should not be labeled")
+	}
+	for _, line := range []string{
+		`counter_http_request_duration_seconds_count{method="POST",route="/pv/increment",status="200"}`,
+		`counter_http_request_duration_seconds_count{method="GET",route="unmatched",status="404"}`,
+		`counter_namespace_increments_total{kind="pv",namespace="metricstest"}`,
+		`counter_namespace_increments_total{kind="uv",namespace="metricstest"}`,
+		`counter_method_calls_total{method="increment_pv"}`,
+		`counter_redis_command_duration_seconds_count{command="get"}`,
+		`counter_redis_pool_total_conns`,
+	} {
+		if !strings.Contains(body, line) {
+			fmt.Println(body)
+			t.Errorf("metrics should contain %s", line)
+		}
+	}
+}
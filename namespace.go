@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// kinds of keys stored under a namespace, like `key@<namespace>@<key>`,
+// some of them have a key of the namespace itself like `top@<namespace>`
+var namespaceKeyKinds = []string{"key", "uv", "history", "top", "token", "dedup", "bot", "settings"}
+
+// namespaceKeyPatterns matches all keys of namespace,
+// the namespace itself comes last so it's removed after everything else
+func namespaceKeyPatterns(namespace string) []string {
+	namespace = escapePattern(namespace)
+	patterns := make([]string, 0)
+	for _, kind := range namespaceKeyKinds {
+		patterns = append(patterns, kind+"@"+namespace, kind+"@"+namespace+"@*")
+	}
+	return append(patterns, constructNamespace(namespace))
+}
+
+// deleteNamespaceKeys removes all keys of namespace, and returns how many
+func deleteNamespaceKeys(ctx context.Context, namespace string) (int64, error) {
+	var cnt int64
+	for _, pattern := range namespaceKeyPatterns(namespace) {
+		deleted, err := G_db.DeleteMatchKeys(ctx, pattern)
+		cnt += deleted
+		if err != nil {
+			return cnt, err
+		}
+	}
+	return cnt, nil
+}
+
+// renameNamespaceKey moves key like `key@<namespace>@<key>` to newNamespace
+func renameNamespaceKey(key, newNamespace string) string {
+	parts := strings.SplitN(key, "@", 3)
+	parts[1] = newNamespace
+	return strings.Join(parts, "@")
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, SECRET_RANDOM_LENGTH)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate secret failed. err[%v]", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func DeleteNamespace(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "delete_namespace")
+
+	namespace := c.Query("namespace")
+	if ok := checkNamespace(namespace, c); !ok {
+		return
+	}
+	if ok := isAuthorized(namespace, SCOPE_ADMIN, c); !ok {
+		return
+	}
+
+	cnt, err := deleteNamespaceKeys(ctx, namespace)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: fmt.Sprintf("delete namespace with %d keys successfully", cnt),
+	}
+	c.JSON(http.StatusOK, errMsg)
+}
+
+func RenameNamespace(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "rename_namespace")
+
+	namespace := c.Query("namespace")
+	newNamespace := c.Query("new_namespace")
+	if namespace == "" || strings.Contains(namespace, "@") ||
+		newNamespace == "" || strings.Contains(newNamespace, "@") || newNamespace == namespace {
+		c.JSON(http.StatusBadRequest, "need namespace and a different new_namespace, both without @")
+		return
+	}
+	if ok := isAuthorized(namespace, SCOPE_ADMIN, c); !ok {
+		return
+	}
+	result, err := G_db.Get(ctx, constructNamespace(namespace))
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	// the legacy hash is bound to the namespace, it's upgraded when
+	// authenticated by the secret, but not by a token
+	if isLegacySecret(result.value.(string)) {
+		errMsg := ErrorMessage{
+			Code:   4002,
+			ErrMsg: "need secret to upgrade the legacy secret before renaming",
+		}
+		c.JSON(http.StatusBadRequest, errMsg)
+		return
+	}
+	if result, _ := G_db.Get(ctx, constructNamespace(newNamespace)); result != nil {
+		errMsg := ErrorMessage{
+			Code:   4001,
+			ErrMsg: "the new namespace exists",
+		}
+		c.JSON(http.StatusBadRequest, errMsg)
+		return
+	}
+
+	keys := make([]string, 0)
+	newKeys := make([]string, 0)
+	for _, pattern := range namespaceKeyPatterns(namespace) {
+		matched, err := G_db.GetPrefixMatchKeys(ctx, pattern)
+		if err != nil {
+			G_logger.WithContext(ctx).Warn(err)
+			errMsg := ErrorMessage{
+				Code:   5001,
+				ErrMsg: "internal error",
+			}
+			c.JSON(http.StatusInternalServerError, errMsg)
+			return
+		}
+		for _, key := range matched {
+			keys = append(keys, key)
+			newKeys = append(newKeys, renameNamespaceKey(key, newNamespace))
+		}
+	}
+	// all or nothing, it fails if any key of the new namespace shows up meanwhile
+	if err = G_db.Rename(ctx, keys, newKeys); err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: fmt.Sprintf("rename namespace with %d keys successfully", len(keys)),
+	}
+	c.JSON(http.StatusOK, errMsg)
+}
+
+func RotateSecret(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "rotate_secret")
+
+	namespace := c.Query("namespace")
+	if ok := checkNamespace(namespace, c); !ok {
+		return
+	}
+	if ok := isAuthorized(namespace, SCOPE_ADMIN, c); !ok {
+		return
+	}
+
+	var err error
+	newSecret := c.Query("new_secret")
+	generated := newSecret == ""
+	if generated {
+		newSecret, err = generateSecret()
+	}
+	var hashed string
+	if err == nil {
+		hashed, err = hashSecret(newSecret)
+	}
+	if err == nil {
+		err = G_db.Set(ctx, constructNamespace(namespace), hashed, false)
+	}
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "rotate secret successfully",
+	}
+	// the generated secret is only returned here
+	if generated {
+		errMsg.Data = append(errMsg.Data, Data{Key: "secret", Value: newSecret})
+	}
+	c.JSON(http.StatusOK, errMsg)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNamespaceKeyPatterns(t *testing.T) {
+	patterns := namespaceKeyPatterns("a*")
+	if len(patterns) != 2*len(namespaceKeyKinds)+1 || patterns[0] != "key@a\\*" ||
+		patterns[1] != "key@a\\*@*" || patterns[len(patterns)-1] != "namespace@a\\*" {
+		fmt.Println(patterns)
+		t.Fail()
+	}
+	if renameNamespaceKey("history@a@day@2023-01-01@page", "b") != "history@b@day@2023-01-01@page" ||
+		renameNamespaceKey("top@a", "b") != "top@b" {
+		t.Fail()
+	}
+}
+
+func TestNamespaceLifecycle(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	defer func() {
+		for _, namespace := range []string{"lifecycletest", "lifecycletest2", "lifecycletest*"} {
+			for _, pattern := range namespaceKeyPatterns(namespace) {
+				G_db.DeleteMatchKeys(ctx, pattern)
+			}
+		}
+		G_db.Delete(ctx, "call@create_pv", "call@create_token", "call@increment_pv", "call@increment_uv", "call@get_pv",
+			"call@delete_namespace", "call@rename_namespace", "call@rotate_secret")
+	}()
+
+	MockRequest(router, "POST", "/pv/create?namespace=lifecycletest&secret=world", nil, "")
+	MockRequest(router, "POST", "/pv/create?namespace=lifecycletest2&secret=world", nil, "")
+	MockRequest(router, "POST", "/pv/create?namespace=lifecycletest*&secret=world", nil, "")
+	MockRequest(router, "POST", "/pv/increment?namespace=lifecycletest&key=page", nil, "")
+	MockRequest(router, "POST", "/pv/increment?namespace=lifecycletest*&key=page", nil, "")
+	MockRequest(router, "POST", "/uv/increment?namespace=lifecycletest&key=page", nil, "")
+	_, errMsg := MockRequest(router, "POST", "/pv/tokens/create?namespace=lifecycletest&secret=world&name=ops&scopes=admin", nil, "")
+	adminToken := errMsg.Data[0].Value.(string)
+
+	if w, _ := MockRequest(router, "POST", "/pv/namespace/rename?namespace=lifecycletest&new_namespace=lifecycletest", nil, ""); w.Code != 400 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/namespace/rename?namespace=lifecycletest&new_namespace=lifecycletest3&secret=hello", nil, ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/namespace/rename?namespace=lifecycletest&new_namespace=lifecycletest2", bearer(adminToken), ""); w.Code != 400 || errMsg.Code != 4001 {
+		t.Fail()
+	}
+
+	// the special characters of namespace don't match others
+	if w, _ := MockRequest(router, "POST", "/pv/namespace/delete?namespace=lifecycletest*&secret=world", nil, ""); w.Code != 200 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "GET", "/pv/get?namespace=lifecycletest&key=page", nil, ""); w.Code != 200 || errMsg.Data[0].Value != "1" {
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "POST", "/pv/namespace/delete?namespace=lifecycletest2&secret=world", nil, ""); w.Code != 200 {
+		t.Fail()
+	}
+
+	// keys, uv, history, leaderboard and tokens move to the new namespace
+	if w, _ := MockRequest(router, "POST", "/pv/namespace/rename?namespace=lifecycletest&new_namespace=lifecycletest2", bearer(adminToken), ""); w.Code != 200 {
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "GET", "/pv/get?namespace=lifecycletest&key=page", nil, ""); w.Code != 400 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "GET", "/pv/get?namespace=lifecycletest2&key=page", bearer(adminToken), ""); w.Code != 200 || errMsg.Data[0].Value != "1" {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "GET", "/pv/top?namespace=lifecycletest2", nil, ""); w.Code != 200 || len(errMsg.Data) != 1 ||
+		errMsg.Data[0].Key != "key@lifecycletest2@page" {
+		t.Fail()
+	}
+	if keys, _ := G_db.GetPrefixMatchKeys(ctx, "*@lifecycletest@*"); len(keys) != 0 {
+		fmt.Println(keys)
+		t.Fail()
+	}
+
+	// the old secret stops working after rotation
+	w, errMsg := MockRequest(router, "POST", "/pv/namespace/rotate?namespace=lifecycletest2&secret=world", nil, "")
+	if w.Code != 200 || len(errMsg.Data) != 1 {
+		t.Fatal(errMsg)
+	}
+	secret := errMsg.Data[0].Value.(string)
+	if w, _ := MockRequest(router, "POST", "/pv/namespace/rotate?namespace=lifecycletest2&secret=world&new_secret=hello", nil, ""); w.Code != 400 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/namespace/rotate?namespace=lifecycletest2&secret="+secret+"&new_secret=hello", nil, ""); w.Code != 200 || len(errMsg.Data) != 0 {
+		t.Fail()
+	}
+	if !checkAuthentication(ctx, "lifecycletest2", "hello") {
+		t.Fail()
+	}
+
+	// the legacy secret is bound to the namespace, so it can't be renamed by a token
+	_ = G_db.Set(ctx, "namespace@lifecycletest2", legacySecret("lifecycletest2", "hello"), false)
+	if w, errMsg := MockRequest(router, "POST", "/pv/namespace/rename?namespace=lifecycletest2&new_namespace=lifecycletest", bearer(adminToken), ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+
+	if w, errMsg := MockRequest(router, "POST", "/pv/namespace/delete?namespace=lifecycletest2", bearer(adminToken), ""); w.Code != 200 || errMsg.Code != 0 {
+		t.Fail()
+	}
+	if keys, _ := G_db.GetPrefixMatchKeys(ctx, "*@lifecycletest2*"); len(keys) != 0 {
+		fmt.Println(keys)
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/namespace/delete?namespace=lifecycletest2", bearer(adminToken), ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+}
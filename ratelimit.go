@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RATE_LIMIT_LOCAL = "local"
+	RATE_LIMIT_STORE = "store"
+)
+
+// routes counted by the per visitor limit
+var rateLimitIncrementRoutes = map[string]bool{
+	"/pv/increment": true,
+	"/uv/increment": true,
+	"/badge/pv.svg": true,
+}
+
+// RateLimiter counts requests in fixed windows, keys like
+// `ratelimit@ip@<ip>@<window>` expire with the window
+type RateLimiter struct {
+	db     Store
+	config RateLimitConfig
+	logger *logrus.Logger
+
+	// for test
+	now func() time.Time
+}
+
+// rateLimitResult is the state of one layer after counting the request
+type rateLimitResult struct {
+	// ip, namespace or visitor
+	layer     string
+	limit     int64
+	remaining int64
+	reset     time.Duration
+}
+
+// NewRateLimiter counts in db for backend `store`, otherwise in a
+// private memory store which is not shared with other replicas
+func NewRateLimiter(config RateLimitConfig, db Store, logger *logrus.Logger) *RateLimiter {
+	if config.Backend != RATE_LIMIT_STORE {
+		db = NewMemoryStore("", time.Minute, time.Minute, logger)
+	}
+	return &RateLimiter{
+		db:     db,
+		config: config,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+type rateLimitLayer struct {
+	name string
+	rule RateLimitRule
+	key  string
+}
+
+// layers of the request, from the widest to the narrowest
+func (limiter *RateLimiter) layers(c *gin.Context) []rateLimitLayer {
+	layers := make([]rateLimitLayer, 0)
+	add := func(name string, rule RateLimitRule, key string) {
+		if rule.Limit > 0 {
+			layers = append(layers, rateLimitLayer{name: name, rule: rule, key: name + "@" + key})
+		}
+	}
+	add("ip", limiter.config.PerIp, c.ClientIP())
+	// a preflight isn't a read or an increment by itself
+	if c.Request.Method == http.MethodOptions {
+		return layers
+	}
+	// namespaces of a batch are in the body, every item counts as one increment
+	if c.FullPath() == "/pv/increment/batch" {
+		for _, item := range parseBatchIncrementItems(c) {
+			add("namespace", limiter.config.PerNamespace, item.Namespace)
+			add("visitor", limiter.config.PerVisitor, fmt.Sprintf("%s@%s@%s", item.Namespace, item.Key, getClientFingerprint(c)))
+		}
+		return layers
+	}
+	namespace := c.Query("namespace")
+	if namespace == "" {
+		return layers
+	}
+	add("namespace", limiter.config.PerNamespace, namespace)
+	if rateLimitIncrementRoutes[c.FullPath()] && c.Query("readonly") != "1" {
+		add("visitor", limiter.config.PerVisitor, fmt.Sprintf("%s@%s@%s", namespace, c.Query("key"), getClientFingerprint(c)))
+	}
+	return layers
+}
+
+// Take counts the request in every layer, returns whether it's allowed
+// and the layer closest to its limit
+func (limiter *RateLimiter) Take(c *gin.Context) (bool, *rateLimitResult, error) {
+	ctx := c.Request.Context()
+	layers := limiter.layers(c)
+	if len(layers) == 0 {
+		return true, nil, nil
+	}
+	now := limiter.now()
+	keys := make([]string, 0)
+	ttls := make([]time.Duration, 0)
+	resets := make([]time.Duration, 0)
+	for _, layer := range layers {
+		window := int64(layer.rule.Window)
+		index := now.UnixNano() / window
+		keys = append(keys, fmt.Sprintf("ratelimit@%s@%d", layer.key, index))
+		ttls = append(ttls, layer.rule.Window)
+		resets = append(resets, time.Duration((index+1)*window-now.UnixNano()))
+	}
+	results, err := limiter.db.BatchIncr(ctx, keys, ttls)
+	if err != nil {
+		return true, nil, err
+	}
+
+	var closest *rateLimitResult
+	allowed := true
+	for index, item := range results {
+		count, _ := item.value.(int64)
+		result := &rateLimitResult{
+			layer:     layers[index].name,
+			limit:     layers[index].rule.Limit,
+			remaining: layers[index].rule.Limit - count,
+			reset:     resets[index],
+		}
+		if result.remaining < 0 {
+			result.remaining = 0
+			if allowed {
+				allowed = false
+				closest = result
+			}
+		}
+		if allowed && (closest == nil || result.remaining < closest.remaining) {
+			closest = result
+		}
+	}
+	return allowed, closest, nil
+}
+
+// seconds rounded up, so clients never retry too early
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+func RateLimitMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, result, err := limiter.Take(c)
+		if err != nil {
+			// let it go rather than failing every request with the store
+			limiter.logger.WithContext(c.Request.Context()).Warn(err)
+			c.Next()
+			return
+		}
+		if result != nil {
+			c.Header("X-RateLimit-Limit", strconv.FormatInt(result.limit, 10))
+			c.Header("X-RateLimit-Remaining", strconv.FormatInt(result.remaining, 10))
+			c.Header("X-RateLimit-Reset", ceilSeconds(result.reset))
+		}
+		if !allowed {
+			rateLimitRejections.WithLabelValues(result.layer).Inc()
+			c.Header("Retry-After", ceilSeconds(result.reset))
+			c.AbortWithStatus(429)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func MockRateLimitRouter(config RateLimitConfig, db Store) *gin.Engine {
+	r := gin.Default()
+	logger := MockNewLogger()
+	limiter := NewRateLimiter(config, db, logger)
+	// stay in the same window
+	now := time.Now().Truncate(time.Minute).Add(time.Second)
+	limiter.now = func() time.Time { return now }
+	r.Use(RateLimitMiddleware(limiter))
+	for path := range map[string]bool{"/pv/get": true, "/pv/increment": true} {
+		r.Any(path, func(c *gin.Context) {
+			c.String(http.StatusOK, "ok")
+		})
+	}
+	r.POST("/pv/increment/batch", func(c *gin.Context) {
+		// the body is still there for the handler
+		c.String(http.StatusOK, fmt.Sprint(len(parseBatchIncrementItems(c))))
+	})
+	return r
+}
+
+func TestRateLimitPerIp(t *testing.T) {
+	router := MockRateLimitRouter(RateLimitConfig{
+		Backend: RATE_LIMIT_LOCAL,
+		PerIp:   RateLimitRule{Limit: 3, Window: time.Minute},
+	}, nil)
+	defer CleanLog()
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/pv/get?namespace=test", nil)
+		router.ServeHTTP(w, req)
+		if w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "3" ||
+			w.Header().Get("X-RateLimit-Remaining") != fmt.Sprint(2-i) || w.Header().Get("X-RateLimit-Reset") != "59" {
+			fmt.Println(w.Code, w.Header())
+			t.Fail()
+		}
+	}
+	rejections := testutil.ToFloat64(rateLimitRejections.WithLabelValues("ip"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/pv/get?namespace=test", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != 429 || w.Header().Get("Retry-After") != "59" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		fmt.Println(w.Code, w.Header())
+		t.Fail()
+	}
+	if testutil.ToFloat64(rateLimitRejections.WithLabelValues("ip")) != rejections+1 {
+		t.Fail()
+	}
+
+	// another client has its own limit
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/pv/get?namespace=test", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	router.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fail()
+	}
+}
+
+func TestRateLimitPerVisitor(t *testing.T) {
+	db := MockNewRedisClient()
+	router := MockRateLimitRouter(RateLimitConfig{
+		Backend:      RATE_LIMIT_STORE,
+		PerIp:        RateLimitRule{Limit: 100, Window: time.Minute},
+		PerNamespace: RateLimitRule{Limit: 5, Window: time.Minute},
+		PerVisitor:   RateLimitRule{Limit: 1, Window: time.Minute},
+	}, db)
+	defer CleanLog()
+	defer func() {
+		keys, _ := db.GetPrefixMatchKeys(ctx, "ratelimit@*")
+		db.Delete(ctx, keys...)
+	}()
+
+	if w, _ := MockRequest(router, "POST", "/pv/increment?namespace=ratetest&key=a", nil, ""); w.Code != 200 || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fail()
+	}
+	// the visitor id in query doesn't bypass the limit
+	if w, _ := MockRequest(router, "POST", "/pv/increment?namespace=ratetest&key=a&visitor=1", nil, ""); w.Code != 429 {
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "POST", "/pv/increment?namespace=ratetest&key=b", nil, ""); w.Code != 200 {
+		t.Fail()
+	}
+	// preflights are counted by ip only
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "/pv/increment?namespace=ratetest&key=c", nil)
+	router.ServeHTTP(w, req)
+	if w, _ := MockRequest(router, "POST", "/pv/increment?namespace=ratetest&key=c", nil, ""); w.Code != 200 {
+		t.Fail()
+	}
+
+	// not an increment
+	if w, _ := MockRequest(router, "POST", "/pv/get?namespace=ratetest&key=a", nil, ""); w.Code != 200 || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		fmt.Println(w.Header())
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "POST", "/pv/get?namespace=ratetest&key=a", nil, ""); w.Code != 429 {
+		t.Fail()
+	}
+
+	// counted in the store, shared with other replicas
+	keys, err := db.GetPrefixMatchKeys(ctx, "ratelimit@namespace@ratetest@*")
+	if err != nil || len(keys) != 1 {
+		t.Fail()
+	}
+}
+
+func TestRateLimitBatch(t *testing.T) {
+	router := MockRateLimitRouter(RateLimitConfig{
+		Backend:      RATE_LIMIT_LOCAL,
+		PerIp:        RateLimitRule{Limit: 100, Window: time.Minute},
+		PerNamespace: RateLimitRule{Limit: 3, Window: time.Minute},
+		PerVisitor:   RateLimitRule{Limit: 1, Window: time.Minute},
+	}, nil)
+	defer CleanLog()
+
+	// every item is charged to its namespace and visitor
+	w, _ := MockRequest(router, "POST", "/pv/increment/batch", nil, `{"items": [{"namespace": "ratebatchtest", "key": "a"}, {"namespace": "ratebatchtest", "key": "b"}]}`)
+	if w.Code != 200 || w.Body.String() != "2" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		fmt.Println(w.Code, w.Header(), w.Body.String())
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "POST", "/pv/increment/batch", nil, `{"items": [{"namespace": "ratebatchtest", "key": "a"}]}`); w.Code != 429 {
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "POST", "/pv/increment/batch", nil, `{"items": [{"namespace": "ratebatchtest", "key": "c"}, {"namespace": "ratebatchtest", "key": "d"}]}`); w.Code != 429 {
+		t.Fail()
+	}
+}
@@ -3,44 +3,62 @@ package main
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
 )
 
-var ctx = context.Background()
-
-type DB struct {
+type RedisStore struct {
 	redisClient *redis.Client
 	logger      *logrus.Logger
+	keyTTL      time.Duration
 }
 
+var _ Store = (*RedisStore)(nil)
+
 type RedisResult struct {
 	key   string
 	value interface{}
 }
 
-func NewRedisClient(redisUrl string, logger *logrus.Logger) *DB {
-	db := &DB{}
+func NewRedisStore(redisUrl string, keyTTL time.Duration, logger *logrus.Logger) *RedisStore {
+	db := &RedisStore{}
 	opt, err := redis.ParseURL(redisUrl)
 	if err != nil {
-		logger.Info("redis url parse error: ", err)
+		logger.WithField("component", "redis").Info("redis url parse error: ", err)
 		return nil
 	}
 	db.redisClient = redis.NewClient(opt)
+	db.redisClient.AddHook(redisMetricsHook{})
+	db.redisClient.AddHook(redisTracingHook{})
 	db.logger = logger
+	db.keyTTL = keyTTL
 	return db
 }
 
-func (db *DB) RefreshExpire(key string) error {
-	return db.redisClient.Expire(ctx, key, REDIS_KEY_TTL).Err()
+func (db *RedisStore) RefreshExpire(ctx context.Context, key string) error {
+	return db.redisClient.Expire(ctx, key, db.keyTTL).Err()
 }
 
-func (db *DB) Set(key string, value interface{}, use_ttl bool) error {
+func (db *RedisStore) Expire(ctx context.Context, ttl time.Duration, keys ...string) error {
+	pipe := db.redisClient.Pipeline()
+	for _, key := range keys {
+		pipe.Expire(ctx, key, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		errMsg := fmt.Errorf("expire keys%v failed. err[%v]", keys, err)
+		return errMsg
+	}
+	return nil
+}
+
+func (db *RedisStore) Set(ctx context.Context, key string, value interface{}, use_ttl bool) error {
 	// refresh expire automatically
 	var err error
 	if use_ttl {
-		_, err = db.redisClient.Set(ctx, key, value, REDIS_KEY_TTL).Result()
+		_, err = db.redisClient.Set(ctx, key, value, db.keyTTL).Result()
 	} else {
 		// no ttl
 		_, err = db.redisClient.Set(ctx, key, value, 0).Result()
@@ -52,7 +70,16 @@ func (db *DB) Set(key string, value interface{}, use_ttl bool) error {
 	return nil
 }
 
-func (db *DB) Get(key string) (*RedisResult, error) {
+func (db *RedisStore) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	ok, err := db.redisClient.SetNX(ctx, key, value, ttl).Result()
+	if err != nil {
+		errMsg := fmt.Errorf("SetNX key[%s] with value[%s] failed. err[%v]", key, value, err)
+		return false, errMsg
+	}
+	return ok, nil
+}
+
+func (db *RedisStore) Get(ctx context.Context, key string) (*RedisResult, error) {
 	val, err := db.redisClient.Get(ctx, key).Result()
 	switch {
 	case err == redis.Nil:
@@ -66,7 +93,7 @@ func (db *DB) Get(key string) (*RedisResult, error) {
 		return nil, errMsg
 	default:
 		// refresh expire
-		if err = db.RefreshExpire(key); err != nil {
+		if err = db.RefreshExpire(ctx, key); err != nil {
 			errMsg := fmt.Errorf("expire key[%s] failed. err[%v]", key, err)
 			return nil, errMsg
 		}
@@ -74,18 +101,19 @@ func (db *DB) Get(key string) (*RedisResult, error) {
 	}
 }
 
-func (db *DB) BatchGet(keys ...string) ([]RedisResult, error) {
+func (db *RedisStore) BatchGet(ctx context.Context, keys ...string) ([]RedisResult, error) {
 	// using pipeline
 	results := make([]RedisResult, 0)
 	values := make([]*redis.StringCmd, 0)
 	pipe := db.redisClient.Pipeline()
 	for _, key := range keys {
 		val := pipe.Get(ctx, key)
-		pipe.Expire(ctx, key, REDIS_KEY_TTL)
+		pipe.Expire(ctx, key, db.keyTTL)
 		values = append(values, val)
 	}
 	_, err := pipe.Exec(ctx)
-	if err != nil {
+	// missing keys are returned as empty string
+	if err != nil && err != redis.Nil {
 		errMsg := fmt.Errorf("exec pipeline failed. err[%v]", err)
 		return nil, errMsg
 	}
@@ -95,10 +123,27 @@ func (db *DB) BatchGet(keys ...string) ([]RedisResult, error) {
 	return results, nil
 }
 
-func (db *DB) Incr(key string) (*RedisResult, error) {
+func (db *RedisStore) BatchPeek(ctx context.Context, keys ...string) ([]RedisResult, error) {
+	results := make([]RedisResult, 0)
+	if len(keys) == 0 {
+		return results, nil
+	}
+	values, err := db.redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		errMsg := fmt.Errorf("mget keys failed. err[%v]", err)
+		return nil, errMsg
+	}
+	for index, val := range values {
+		value, _ := val.(string)
+		results = append(results, RedisResult{key: keys[index], value: value})
+	}
+	return results, nil
+}
+
+func (db *RedisStore) Incr(ctx context.Context, key string) (*RedisResult, error) {
 	pipe := db.redisClient.Pipeline()
 	incr := pipe.Incr(ctx, key)
-	pipe.Expire(ctx, key, REDIS_KEY_TTL)
+	pipe.Expire(ctx, key, db.keyTTL)
 	_, err := pipe.Exec(ctx)
 	if err != nil {
 		errMsg := fmt.Errorf("incr key[%s] failed. err[%v]", key, err)
@@ -107,11 +152,97 @@ func (db *DB) Incr(key string) (*RedisResult, error) {
 	return &RedisResult{key: key, value: incr.Val()}, nil
 }
 
-func (db *DB) Delete(keys ...string) (int64, error) {
+// incrby atomically, and never goes below zero if ARGV[2] is 1
+var incrByScript = redis.NewScript(`
+local value = redis.call('INCRBY', KEYS[1], ARGV[1])
+if ARGV[2] == '1' and value < 0 then
+	redis.call('SET', KEYS[1], 0)
+	value = 0
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return value
+`)
+
+func (db *RedisStore) IncrBy(ctx context.Context, key string, by int64, floorAtZero bool) (*RedisResult, error) {
+	floor := 0
+	if floorAtZero {
+		floor = 1
+	}
+	value, err := incrByScript.Run(ctx, db.redisClient, []string{key}, by, floor, db.keyTTL.Milliseconds()).Int64()
+	if err != nil {
+		errMsg := fmt.Errorf("incrby key[%s] by[%d] failed. err[%v]", key, by, err)
+		return nil, errMsg
+	}
+	return &RedisResult{key: key, value: value}, nil
+}
+
+func (db *RedisStore) BatchIncr(ctx context.Context, keys []string, ttls []time.Duration) ([]RedisResult, error) {
+	// using pipeline
+	results := make([]RedisResult, 0)
+	values := make([]*redis.IntCmd, 0)
+	pipe := db.redisClient.Pipeline()
+	for index, key := range keys {
+		val := pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, ttls[index])
+		values = append(values, val)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		errMsg := fmt.Errorf("exec pipeline failed. err[%v]", err)
+		return nil, errMsg
+	}
+	for index, val := range values {
+		results = append(results, RedisResult{key: keys[index], value: val.Val()})
+	}
+	return results, nil
+}
+
+// incrby KEYS[i] by ARGV[i+1] and expire in ARGV[1] milliseconds. MULTI/EXEC
+// doesn't roll back a failed command, so all keys are checked first to change
+// nothing if any of them isn't an integer, like the other backends
+var batchIncrByScript = redis.NewScript(`
+for i = 1, #KEYS do
+	local kind = redis.call('TYPE', KEYS[i])['ok']
+	if kind ~= 'none' then
+		local value = kind == 'string' and redis.call('GET', KEYS[i])
+		if not value or not string.match(value, '^-?%d+$') then
+			return redis.error_reply('key[' .. KEYS[i] .. '] is not an integer')
+		end
+	end
+end
+local values = {}
+for i = 1, #KEYS do
+	values[i] = redis.call('INCRBY', KEYS[i], ARGV[i + 1])
+	redis.call('PEXPIRE', KEYS[i], ARGV[1])
+end
+return values
+`)
+
+func (db *RedisStore) BatchIncrBy(ctx context.Context, keys []string, bys []int64) ([]RedisResult, error) {
+	results := make([]RedisResult, 0)
+	if len(keys) == 0 {
+		return results, nil
+	}
+	args := []interface{}{db.keyTTL.Milliseconds()}
+	for _, by := range bys {
+		args = append(args, by)
+	}
+	values, err := batchIncrByScript.Run(ctx, db.redisClient, keys, args...).Int64Slice()
+	if err != nil {
+		errMsg := fmt.Errorf("batch incrby keys%v failed. err[%v]", keys, err)
+		return nil, errMsg
+	}
+	for index, value := range values {
+		results = append(results, RedisResult{key: keys[index], value: value})
+	}
+	return results, nil
+}
+
+func (db *RedisStore) Delete(ctx context.Context, keys ...string) (int64, error) {
 	return db.redisClient.Del(ctx, keys...).Result()
 }
 
-func (db *DB) GetPrefixMatchKeys(pattern string) ([]string, error) {
+func (db *RedisStore) GetPrefixMatchKeys(ctx context.Context, pattern string) ([]string, error) {
 	// using `scan` instead of `keys`
 	allKeys := make([]string, 0)
 	var cursor uint64
@@ -131,3 +262,181 @@ func (db *DB) GetPrefixMatchKeys(pattern string) ([]string, error) {
 	}
 	return allKeys, nil
 }
+
+func (db *RedisStore) DeleteMatchKeys(ctx context.Context, pattern string) (int64, error) {
+	var cnt int64
+	var cursor uint64
+	for {
+		var keys []string
+		var err error
+		keys, cursor, err = db.redisClient.Scan(ctx, cursor, pattern, STORE_DELETE_BATCH).Result()
+		if err != nil {
+			errMsg := fmt.Errorf("scan pattern[%s] failed. err:[%v]", pattern, err)
+			return cnt, errMsg
+		}
+		if len(keys) > 0 {
+			deleted, err := db.redisClient.Del(ctx, keys...).Result()
+			if err != nil {
+				errMsg := fmt.Errorf("delete keys of pattern[%s] failed. err[%v]", pattern, err)
+				return cnt, errMsg
+			}
+			cnt += deleted
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	return cnt, nil
+}
+
+// rename KEYS[i] to KEYS[n+i] with n = #KEYS / 2, atomically
+var renameScript = redis.NewScript(`
+local n = #KEYS / 2
+for i = 1, n do
+	if redis.call('EXISTS', KEYS[n + i]) == 1 then
+		return redis.error_reply('key[' .. KEYS[n + i] .. '] exists')
+	end
+end
+for i = 1, n do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		redis.call('RENAME', KEYS[i], KEYS[n + i])
+	end
+end
+return n
+`)
+
+func (db *RedisStore) Rename(ctx context.Context, keys []string, newKeys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	err := renameScript.Run(ctx, db.redisClient, append(append([]string{}, keys...), newKeys...)).Err()
+	if err != nil {
+		errMsg := fmt.Errorf("rename keys%v failed. err[%v]", keys, err)
+		return errMsg
+	}
+	return nil
+}
+
+func (db *RedisStore) PfAdd(ctx context.Context, key string, elements ...interface{}) (*RedisResult, error) {
+	pipe := db.redisClient.Pipeline()
+	pipe.PFAdd(ctx, key, elements...)
+	count := pipe.PFCount(ctx, key)
+	pipe.Expire(ctx, key, db.keyTTL)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		errMsg := fmt.Errorf("pfadd key[%s] failed. err[%v]", key, err)
+		return nil, errMsg
+	}
+	return &RedisResult{key: key, value: count.Val()}, nil
+}
+
+func (db *RedisStore) PfCount(ctx context.Context, key string) (*RedisResult, error) {
+	pipe := db.redisClient.Pipeline()
+	exists := pipe.Exists(ctx, key)
+	count := pipe.PFCount(ctx, key)
+	pipe.Expire(ctx, key, db.keyTTL)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		errMsg := fmt.Errorf("pfcount key[%s] failed. err[%v]", key, err)
+		return nil, errMsg
+	}
+	if exists.Val() == 0 {
+		errMsg := fmt.Errorf("key[%s] does not exist", key)
+		return nil, errMsg
+	}
+	return &RedisResult{key: key, value: count.Val()}, nil
+}
+
+func (db *RedisStore) BatchPfCount(ctx context.Context, keys ...string) ([]RedisResult, error) {
+	// using pipeline
+	results := make([]RedisResult, 0)
+	values := make([]*redis.IntCmd, 0)
+	pipe := db.redisClient.Pipeline()
+	for _, key := range keys {
+		val := pipe.PFCount(ctx, key)
+		pipe.Expire(ctx, key, db.keyTTL)
+		values = append(values, val)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		errMsg := fmt.Errorf("exec pipeline failed. err[%v]", err)
+		return nil, errMsg
+	}
+	for index, val := range values {
+		results = append(results, RedisResult{key: keys[index], value: val.Val()})
+	}
+	return results, nil
+}
+
+func (db *RedisStore) Close() error {
+	return db.redisClient.Close()
+}
+
+func (db *RedisStore) BatchZIncrBy(ctx context.Context, keys []string, ttls []time.Duration, member string, by int64) error {
+	pipe := db.redisClient.Pipeline()
+	for index, key := range keys {
+		pipe.ZIncrBy(ctx, key, float64(by), member)
+		pipe.Expire(ctx, key, ttls[index])
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		errMsg := fmt.Errorf("exec pipeline failed. err[%v]", err)
+		return errMsg
+	}
+	return nil
+}
+
+func (db *RedisStore) ZAdd(ctx context.Context, key string, member string, score int64) error {
+	pipe := db.redisClient.Pipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(score), Member: member})
+	pipe.Expire(ctx, key, db.keyTTL)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		errMsg := fmt.Errorf("zadd key[%s] failed. err[%v]", key, err)
+		return errMsg
+	}
+	return nil
+}
+
+func (db *RedisStore) ZRem(ctx context.Context, keys []string, member string) error {
+	pipe := db.redisClient.Pipeline()
+	for _, key := range keys {
+		pipe.ZRem(ctx, key, member)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		errMsg := fmt.Errorf("exec pipeline failed. err[%v]", err)
+		return errMsg
+	}
+	return nil
+}
+
+func (db *RedisStore) ZTop(ctx context.Context, keys []string, limit int) ([]RedisResult, error) {
+	var values []redis.Z
+	var err error
+	if len(keys) == 1 {
+		values, err = db.redisClient.ZRevRangeWithScores(ctx, keys[0], 0, int64(limit-1)).Result()
+	} else {
+		// sum into a temporary key, which is removed in the same transaction
+		dest := fmt.Sprintf("tmp@top@%d", time.Now().UnixNano())
+		var top *redis.ZSliceCmd
+		_, err = db.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZUnionStore(ctx, dest, &redis.ZStore{Keys: keys})
+			top = pipe.ZRevRangeWithScores(ctx, dest, 0, int64(limit-1))
+			pipe.Del(ctx, dest)
+			return nil
+		})
+		if top != nil {
+			values = top.Val()
+		}
+	}
+	if err != nil {
+		errMsg := fmt.Errorf("get top of keys%v failed. err[%v]", keys, err)
+		return nil, errMsg
+	}
+	results := make([]RedisResult, 0)
+	for _, z := range values {
+		results = append(results, RedisResult{key: z.Member.(string), value: int64(z.Score)})
+	}
+	return results, nil
+}
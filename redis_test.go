@@ -1,14 +1,35 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
 )
 
-func MockNewRedisClient() *DB {
-	logger := NewLogger()
+var mockRedis *miniredis.Miniredis
+
+// context of store calls outside requests
+var ctx = context.Background()
+
+// in-process redis, shared by all tests like a real server
+func MockRedisUrl() string {
+	if mockRedis == nil {
+		var err error
+		mockRedis, err = miniredis.Run()
+		if err != nil {
+			panic(err)
+		}
+	}
+	return fmt.Sprintf("redis://%s/0", mockRedis.Addr())
+}
+
+func MockNewRedisClient() *RedisStore {
+	logger := MockNewLogger()
 
-	db := NewRedisClient(DEFAULT_REDIS_URL, logger)
+	db := NewRedisStore(MockRedisUrl(), DefaultConfig().Store.KeyTTL, logger)
 	return db
 }
 
@@ -22,7 +43,7 @@ func TestNewRedisClient(t *testing.T) {
 func TestGet(t *testing.T) {
 	db := MockNewRedisClient()
 	defer CleanLog()
-	ret, err := db.Get("hello")
+	ret, err := db.Get(ctx, "hello")
 	if ret != nil || err == nil {
 		t.Fail()
 	}
@@ -32,41 +53,117 @@ func TestGet(t *testing.T) {
 func TestSet(t *testing.T) {
 	db := MockNewRedisClient()
 	defer CleanLog()
-	err := db.Set("test", "yes", true)
+	err := db.Set(ctx, "test", "yes", true)
 	if err != nil {
 		fmt.Println(err)
 		t.Fail()
 	}
-	ret, err := db.Get("test")
+	ret, err := db.Get(ctx, "test")
 	if ret.value != "yes" || err != nil {
 		fmt.Println(err)
 		t.Fail()
 	}
 }
 
+func TestSetNX(t *testing.T) {
+	db := MockNewRedisClient()
+	defer CleanLog()
+	defer db.Delete(ctx, "setnx")
+
+	if ok, err := db.SetNX(ctx, "setnx", 1, time.Minute); !ok || err != nil {
+		t.Fail()
+	}
+	if ok, err := db.SetNX(ctx, "setnx", 2, time.Minute); ok || err != nil {
+		t.Fail()
+	}
+	mockRedis.FastForward(time.Minute)
+	if ok, err := db.SetNX(ctx, "setnx", 3, time.Minute); !ok || err != nil {
+		t.Fail()
+	}
+}
+
 func TestIncr(t *testing.T) {
 	db := MockNewRedisClient()
 	defer CleanLog()
-	err := db.Set("counter", 0, true)
-	ret, err := db.Incr("counter")
+	err := db.Set(ctx, "counter", 0, true)
+	ret, err := db.Incr(ctx, "counter")
 	if ret.value.(int64) != 1 || err != nil {
 		fmt.Println(err)
 		t.Fail()
 	}
 
 	// failure case
-	ret, err = db.Incr("test")
+	ret, err = db.Incr(ctx, "test")
 	fmt.Println(ret, err)
 	if err == nil {
 		t.Fail()
 	}
 }
 
+func TestIncrBy(t *testing.T) {
+	db := MockNewRedisClient()
+	defer CleanLog()
+	defer db.Delete(ctx, "incrby")
+
+	ret, err := db.IncrBy(ctx, "incrby", 5, false)
+	if err != nil || ret.value.(int64) != 5 {
+		fmt.Println(err)
+		t.Fail()
+	}
+	ret, err = db.IncrBy(ctx, "incrby", -7, false)
+	if err != nil || ret.value.(int64) != -2 {
+		t.Fail()
+	}
+	ret, err = db.IncrBy(ctx, "incrby", -1, true)
+	if err != nil || ret.value.(int64) != 0 {
+		t.Fail()
+	}
+	if ret, err := db.Get(ctx, "incrby"); err != nil || ret.value != "0" || mockRedis.TTL("incrby") <= 0 {
+		t.Fail()
+	}
+
+	// failure case
+	_ = db.Set(ctx, "incrby", "yes", true)
+	if ret, err := db.IncrBy(ctx, "incrby", 1, true); ret != nil || err == nil {
+		t.Fail()
+	}
+}
+
+func TestBatchIncrBy(t *testing.T) {
+	db := MockNewRedisClient()
+	defer CleanLog()
+	defer db.Delete(ctx, "incrby-a", "incrby-b", "incrby-c", "incrby-d")
+
+	results, err := db.BatchIncrBy(ctx, []string{"incrby-a", "incrby-b", "incrby-a"}, []int64{1, 5, 10})
+	if err != nil || len(results) != 3 || results[1].value.(int64) != 5 || results[2].value.(int64) != 11 {
+		fmt.Println(err, results)
+		t.Fail()
+	}
+	if mockRedis.TTL("incrby-b") <= 0 {
+		t.Fail()
+	}
+
+	// all or nothing, even for a key of another type
+	_ = db.Set(ctx, "incrby-c", "yes", true)
+	_ = db.BatchZIncrBy(ctx, []string{"incrby-d"}, []time.Duration{time.Minute}, "member", 1)
+	for _, key := range []string{"incrby-c", "incrby-d"} {
+		if _, err := db.BatchIncrBy(ctx, []string{"incrby-a", key}, []int64{1, 1}); err == nil {
+			t.Errorf("incr %s should fail", key)
+		}
+	}
+	if ret, _ := db.Get(ctx, "incrby-a"); ret.value != "11" {
+		t.Fail()
+	}
+	if results, err := db.BatchIncrBy(ctx, nil, nil); err != nil || len(results) != 0 {
+		t.Fail()
+	}
+}
+
 func TestBatchGet(t *testing.T) {
 	db := MockNewRedisClient()
 	defer CleanLog()
 
-	results, err := db.BatchGet("test", "counter")
+	results, err := db.BatchGet(ctx, "test", "counter")
 	if results == nil || len(results) != 2 || err != nil {
 		fmt.Println(err)
 		t.Fail()
@@ -78,19 +175,19 @@ func TestDel(t *testing.T) {
 	db := MockNewRedisClient()
 	defer CleanLog()
 
-	cnt, err := db.Delete("how")
+	cnt, err := db.Delete(ctx, "how")
 	if cnt != 0 || err != nil {
 		fmt.Println(err)
 		t.Fail()
 	}
-	cnt, err = db.Delete("counter", "test")
+	cnt, err = db.Delete(ctx, "counter", "test")
 	fmt.Println(cnt, err)
 	if cnt != 2 || err != nil {
 		fmt.Println(err)
 		t.Fail()
 	}
 
-	ret, err := db.Get("test")
+	ret, err := db.Get(ctx, "test")
 	fmt.Println(ret, err)
 	if ret != nil || err == nil {
 		fmt.Println(err)
@@ -103,15 +200,144 @@ func TestGetPrefixMatchKeys(t *testing.T) {
 	defer CleanLog()
 
 	for i := 0; i < 33; i++ {
-		err := db.Set(fmt.Sprintf("key%d", i), "value", true)
+		err := db.Set(ctx, fmt.Sprintf("key%d", i), "value", true)
 		if err != nil {
 			t.Fail()
 		}
 	}
-	keys, err := db.GetPrefixMatchKeys("key*")
+	keys, err := db.GetPrefixMatchKeys(ctx, "key*")
 	if len(keys) != 33 || err != nil {
 		fmt.Println(err)
 		t.Fail()
 	}
 	fmt.Println(keys)
 }
+
+func TestDeleteMatchKeysAndRename(t *testing.T) {
+	db := MockNewRedisClient()
+	defer CleanLog()
+
+	for i := 0; i < 250; i++ {
+		_ = db.Set(ctx, fmt.Sprintf("renametest@a@%d", i), i, true)
+	}
+	_ = db.Set(ctx, "renametest@b@0", 0, true)
+	defer db.DeleteMatchKeys(ctx, "renametest@*")
+
+	// nothing changes if any new key exists
+	if err := db.Rename(ctx, []string{"renametest@a@0", "renametest@a@1"}, []string{"renametest@c@0", "renametest@b@0"}); err == nil {
+		t.Fail()
+	}
+	if ret, _ := db.Get(ctx, "renametest@a@0"); ret == nil {
+		t.Fail()
+	}
+	// missing keys are skipped, ttl is kept
+	if err := db.Rename(ctx, []string{"renametest@a@0", "renametest@a@999"}, []string{"renametest@c@0", "renametest@c@999"}); err != nil {
+		fmt.Println(err)
+		t.Fail()
+	}
+	if ret, _ := db.Get(ctx, "renametest@c@0"); ret == nil || ret.value != "0" || mockRedis.TTL("renametest@c@0") == 0 {
+		t.Fail()
+	}
+
+	cnt, err := db.DeleteMatchKeys(ctx, "renametest@a@*")
+	if cnt != 249 || err != nil {
+		fmt.Println(cnt, err)
+		t.Fail()
+	}
+	keys, _ := db.GetPrefixMatchKeys(ctx, "renametest@*")
+	if len(keys) != 2 {
+		fmt.Println(keys)
+		t.Fail()
+	}
+}
+
+func TestPfAddAndCount(t *testing.T) {
+	db := MockNewRedisClient()
+	defer CleanLog()
+	defer db.Delete(ctx, "hll")
+
+	ret, err := db.PfCount(ctx, "hll")
+	if ret != nil || err == nil {
+		t.Fail()
+	}
+	for _, visitor := range []string{"a", "b", "a"} {
+		ret, err = db.PfAdd(ctx, "hll", visitor)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+		}
+	}
+	if ret.value.(int64) != 2 {
+		t.Fail()
+	}
+	ret, err = db.PfCount(ctx, "hll")
+	if err != nil || ret.value.(int64) != 2 {
+		fmt.Println(err)
+		t.Fail()
+	}
+	results, err := db.BatchPfCount(ctx, "hll", "hll-none")
+	if err != nil || len(results) != 2 || results[0].value.(int64) != 2 || results[1].value.(int64) != 0 {
+		fmt.Println(err)
+		t.Fail()
+	}
+}
+
+func TestBatchIncrAndPeek(t *testing.T) {
+	db := MockNewRedisClient()
+	defer CleanLog()
+	defer db.Delete(ctx, "bucket1", "bucket2")
+
+	results, err := db.BatchIncr(ctx, []string{"bucket1", "bucket2"}, []time.Duration{time.Hour, time.Minute})
+	if err != nil || len(results) != 2 || results[1].value.(int64) != 1 {
+		fmt.Println(err)
+		t.Fail()
+	}
+	if ttl := mockRedis.TTL("bucket2"); ttl != time.Minute {
+		t.Fail()
+	}
+
+	results, err = db.BatchPeek(ctx, "bucket1", "none")
+	if err != nil || len(results) != 2 || results[0].value != "1" || results[1].value != "" {
+		fmt.Println(err)
+		t.Fail()
+	}
+	// peek doesn't refresh ttl
+	if ttl := mockRedis.TTL("bucket1"); ttl != time.Hour {
+		t.Fail()
+	}
+
+	// missing keys don't fail batch get
+	results, err = db.BatchGet(ctx, "bucket1", "none")
+	if err != nil || len(results) != 2 || results[1].value != "" {
+		fmt.Println(err)
+		t.Fail()
+	}
+}
+
+func TestZTop(t *testing.T) {
+	db := MockNewRedisClient()
+	defer CleanLog()
+	defer db.Delete(ctx, "z1", "z2")
+
+	ttls := []time.Duration{time.Hour, time.Hour}
+	_ = db.BatchZIncrBy(ctx, []string{"z1", "z2"}, ttls, "a", 1)
+	_ = db.BatchZIncrBy(ctx, []string{"z2"}, ttls, "b", 3)
+	_ = db.ZAdd(ctx, "z1", "c", 5)
+
+	results, err := db.ZTop(ctx, []string{"z1"}, 10)
+	if err != nil || len(results) != 2 || results[0].key != "c" || results[0].value.(int64) != 5 {
+		fmt.Println(err, results)
+		t.Fail()
+	}
+	results, err = db.ZTop(ctx, []string{"z1", "z2"}, 2)
+	if err != nil || len(results) != 2 || results[1].key != "b" || results[1].value.(int64) != 3 {
+		fmt.Println(err, results)
+		t.Fail()
+	}
+
+	_ = db.ZRem(ctx, []string{"z1", "z2"}, "a")
+	results, _ = db.ZTop(ctx, []string{"z1", "z2"}, 10)
+	if len(results) != 2 {
+		t.Fail()
+	}
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
-	"crypto/md5"
+	"context"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -22,7 +23,7 @@ type ErrorMessage struct {
 	Data   []Data `json:"data"`
 }
 
-var G_db *DB
+var G_db Store
 var G_logger *logrus.Logger
 
 func isInt(s string) bool {
@@ -32,10 +33,11 @@ func isInt(s string) bool {
 	return true
 }
 
-func incrMethodCalls(method string) error {
-	_, err := G_db.Incr("call@" + method)
+func incrMethodCalls(ctx context.Context, method string) error {
+	methodCalls.WithLabelValues(method).Inc()
+	_, err := G_db.Incr(ctx, "call@"+method)
 	if err != nil {
-		G_logger.Errorf("incr method calls failed. err: %v", err)
+		G_logger.WithContext(ctx).Errorf("incr method calls failed. err: %v", err)
 	}
 	return err
 }
@@ -49,26 +51,17 @@ func constructKey(namespace, key string) string {
 }
 
 func isNamespaceValid(namespace string, c *gin.Context) bool {
+	ctx := c.Request.Context()
 	namespace = constructNamespace(namespace)
-	_, err := G_db.Get(namespace)
+	_, err := G_db.Get(ctx, namespace)
 	if err != nil {
-		G_logger.Warn(err)
+		G_logger.WithContext(ctx).Warn(err)
 		c.JSON(http.StatusBadRequest, "invalid namespace")
 		return false
 	}
 	return true
 }
 
-// encrypt the password
-func generateScrect(namespace string, secret string) (string, error) {
-	h := md5.New()
-	_, err := h.Write([]byte(namespace + secret))
-	if err != nil {
-		return "", err
-	}
-	return string(h.Sum(nil)), nil
-}
-
 func checkNamespace(namespace string, c *gin.Context) bool {
 	if namespace == "" || strings.Contains(namespace, "@") {
 		c.JSON(http.StatusBadRequest, "need namespace without @")
@@ -85,46 +78,44 @@ func checkNamespaceAndKey(namespace, key string, c *gin.Context) bool {
 	return true
 }
 
-func checkNamespaceSecretKey(namespace, secret, key string, c *gin.Context) bool {
-	if namespace == "" || strings.Contains(namespace, "@") ||
-		secret == "" || key == "" {
-		c.JSON(http.StatusBadRequest, "need namespace without @, secret and key")
-		return false
-	}
-	return true
-}
-
-func checkNamespaceSecretKeyValue(namespace, secret, key, value string, c *gin.Context) bool {
+func checkNamespaceKeyValue(namespace, key, value string, c *gin.Context) bool {
 	if namespace == "" || strings.Contains(namespace, "@") ||
-		secret == "" || key == "" || value == "" || !isInt(value) {
-		c.JSON(http.StatusBadRequest, "need namespace without @, secret, key and valid value (integer)")
+		key == "" || value == "" || !isInt(value) {
+		c.JSON(http.StatusBadRequest, "need namespace without @, key and valid value (integer)")
 		return false
 	}
 	return true
 }
 
-func checkAuthentication(namespace, secret string) bool {
-	namespace = constructNamespace(namespace)
-	result, err := G_db.Get(namespace)
-	if err != nil {
-		G_logger.Warn(err)
-		return false
-	}
-	compareScript, err := generateScrect(namespace, secret)
+func checkAuthentication(ctx context.Context, namespace, secret string) bool {
+	newNamespace := constructNamespace(namespace)
+	result, err := G_db.Get(ctx, newNamespace)
 	if err != nil {
-		G_logger.Warn(err)
+		G_logger.WithContext(ctx).Warn(err)
 		return false
 	}
-	if result.value.(string) != compareScript {
+	ok, rehash := verifySecret(namespace, secret, result.value.(string))
+	if !ok {
 		errMsg := fmt.Errorf("secret is invalid")
-		G_logger.Warn(errMsg)
+		G_logger.WithContext(ctx).Warn(errMsg)
 		return false
 	}
+	// upgrade the legacy hash transparently, the secret is valid anyway
+	if rehash {
+		hashed, err := hashSecret(secret)
+		if err == nil {
+			err = G_db.Set(ctx, newNamespace, hashed, false)
+		}
+		if err != nil {
+			G_logger.WithContext(ctx).Warnf("rehash secret of namespace[%s] failed. err[%v]", namespace, err)
+		}
+	}
 	return true
 }
 
 func GetPv(c *gin.Context) {
-	incrMethodCalls("get_pv")
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "get_pv")
 
 	namespace := c.Query("namespace")
 	if ok := checkNamespace(namespace, c); !ok {
@@ -133,13 +124,17 @@ func GetPv(c *gin.Context) {
 	if ok := isNamespaceValid(namespace, c); !ok {
 		return
 	}
-
 	key := c.Query("key")
+	settings, ok := isReadAllowed(namespace, key == "", c)
+	if !ok {
+		return
+	}
+
 	// get all keys under namespace
 	if key == "" {
-		newKeys, err := G_db.GetPrefixMatchKeys(fmt.Sprintf("key@%s@*", namespace))
+		newKeys, err := G_db.GetPrefixMatchKeys(ctx, constructKey(escapePattern(namespace), "*"))
 		if err != nil {
-			G_logger.Warn(err)
+			G_logger.WithContext(ctx).Warn(err)
 			errMsg := ErrorMessage{
 				Code:   5001,
 				ErrMsg: "internal error",
@@ -156,9 +151,12 @@ func GetPv(c *gin.Context) {
 			return
 		}
 		// batch get
-		results, err := G_db.BatchGet(newKeys...)
+		results, err := G_db.BatchGet(ctx, newKeys...)
+		if err == nil {
+			err = settings.expireKeys(ctx, newKeys...)
+		}
 		if err != nil {
-			G_logger.Warn(err)
+			G_logger.WithContext(ctx).Warn(err)
 			errMsg := ErrorMessage{
 				Code:   5001,
 				ErrMsg: "internal error",
@@ -178,9 +176,12 @@ func GetPv(c *gin.Context) {
 	}
 	// specific key
 	newKey := constructKey(namespace, key)
-	result, err := G_db.Get(newKey)
+	result, err := G_db.Get(ctx, newKey)
+	if err == nil {
+		err = settings.expireKeys(ctx, newKey)
+	}
 	if err != nil {
-		G_logger.Warn(err)
+		G_logger.WithContext(ctx).Warn(err)
 		if strings.Contains(err.Error(), "does not exist") {
 			errMsg := ErrorMessage{
 				Code:   4001,
@@ -205,7 +206,8 @@ func GetPv(c *gin.Context) {
 }
 
 func CreatePv(c *gin.Context) {
-	incrMethodCalls("create_pv")
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "create_pv")
 
 	namespace := c.Query("namespace")
 	if ok := checkNamespace(namespace, c); !ok {
@@ -215,10 +217,10 @@ func CreatePv(c *gin.Context) {
 	if secret == "" {
 		secret = namespace
 	}
-	namespace = constructNamespace(namespace)
-	result, _ := G_db.Get(namespace)
+	newNamespace := constructNamespace(namespace)
+	result, _ := G_db.Get(ctx, newNamespace)
 	if result != nil {
-		G_logger.Warn(fmt.Sprintf("namespace[%s] exists", namespace))
+		G_logger.WithContext(ctx).Warn(fmt.Sprintf("namespace[%s] exists", newNamespace))
 		errMsg := ErrorMessage{
 			Code:   4001,
 			ErrMsg: "this namespace exists",
@@ -226,19 +228,23 @@ func CreatePv(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, errMsg)
 		return
 	}
-	secret, err := generateScrect(namespace, secret)
-	if err != nil {
-		G_logger.Warn(err)
-		errMsg := ErrorMessage{
-			Code:   5001,
-			ErrMsg: "internal error",
-		}
-		c.JSON(http.StatusInternalServerError, errMsg)
-		return
+	// keys left by an expired namespace of the same name, like its tokens,
+	// don't belong to the new owner
+	_, err := deleteNamespaceKeys(ctx, namespace)
+	if err == nil {
+		secret, err = hashSecret(secret)
+	}
+	// settings go first, so a namespace always has its settings
+	if err == nil {
+		settings := defaultSettings()
+		settings.CreatedAt = time.Now().Unix()
+		err = saveSettings(ctx, namespace, settings)
+	}
+	if err == nil {
+		err = G_db.Set(ctx, newNamespace, secret, false)
 	}
-	err = G_db.Set(namespace, secret, false)
 	if err != nil {
-		G_logger.Warn(err)
+		G_logger.WithContext(ctx).Warn(err)
 		errMsg := ErrorMessage{
 			Code:   5001,
 			ErrMsg: "internal error",
@@ -250,31 +256,191 @@ func CreatePv(c *gin.Context) {
 		Code:   0,
 		ErrMsg: "create namespace successfully",
 	}
-	errMsg.Data = append(errMsg.Data, Data{Key: namespace, Value: secret})
 	c.JSON(http.StatusOK, errMsg)
 }
 
+// getPvValue returns the value of key as int64, 0 if it doesn't exist
+// without refreshing the ttl, as it's not counted
+func getPvValue(ctx context.Context, namespace, key string) (*RedisResult, error) {
+	newKey := constructKey(namespace, key)
+	results, err := G_db.BatchPeek(ctx, newKey)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := strconv.ParseInt(results[0].value.(string), 10, 64)
+	return &RedisResult{key: newKey, value: value}, nil
+}
+
+// incrementPv counts one view of key by visitor, shared by all the increment
+// endpoints. Duplicate hits in the dedup window return the current value
+// and false
+func incrementPv(ctx context.Context, settings *namespaceSettings, namespace, key, visitor string) (*RedisResult, bool, error) {
+	newKey := constructKey(namespace, key)
+	duplicate, err := isDuplicateHit(ctx, namespace, key, visitor, settings.dedupWindow())
+	if err != nil {
+		return nil, false, err
+	}
+	if duplicate {
+		result, err := getPvValue(ctx, namespace, key)
+		return result, false, err
+	}
+
+	var result *RedisResult
+	if err = settings.checkKeyQuota(ctx, namespace, newKey); err == nil {
+		result, err = G_db.Incr(ctx, newKey)
+	}
+	if err != nil {
+		// not counted, so the retry isn't a duplicate
+		forgetHit(ctx, namespace, key, visitor, settings.dedupWindow())
+		return nil, false, err
+	}
+	if err = settings.expireKeys(ctx, newKey); err != nil {
+		return nil, false, err
+	}
+	recordView(ctx, settings, namespace, key, time.Now())
+	return result, true, nil
+}
+
+// recordView adds one view of key to history and leaderboard, which are
+// best effort as the total has been counted
+func recordView(ctx context.Context, settings *namespaceSettings, namespace, key string, now time.Time) {
+	countIncrement(settings, namespace, "pv")
+	if err := recordHistory(ctx, namespace, key, now); err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+	}
+	if err := recordLeaderboard(ctx, settings, namespace, key, now); err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+	}
+}
+
+// checkHit tells why a hit of key is not counted, "" if it should be.
+// Hits from origins not allowed by namespace are dropped, and bots are
+// counted in the bot counter instead
+func checkHit(settings *namespaceSettings, namespace, key string, c *gin.Context) (string, error) {
+	if !settings.allowsOrigin(requestOrigin(c)) {
+		return "origin not allowed, not counted", nil
+	}
+	isBot, err := isBotHit(namespace, key, c)
+	if err != nil || !isBot {
+		return "", err
+	}
+	return "filtered as bot, not counted", nil
+}
+
+// parse `by` of increment and decrement, default to 1
+func checkBy(c *gin.Context) (int64, bool) {
+	by, err := strconv.ParseInt(c.DefaultQuery("by", "1"), 10, 64)
+	if err != nil || by < 1 {
+		c.JSON(http.StatusBadRequest, "need by as positive integer")
+		return 0, false
+	}
+	return by, true
+}
+
+// adjustPv changes key by `by` for imports and corrections, which is not a view,
+// so only the all-time leaderboard follows and history is left untouched
+func adjustPv(ctx context.Context, namespace, key string, by int64, floorAtZero bool) (*RedisResult, error) {
+	settings, err := getSettings(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	newKey := constructKey(namespace, key)
+	if err = settings.checkKeyQuota(ctx, namespace, newKey); err != nil {
+		return nil, err
+	}
+	result, err := G_db.IncrBy(ctx, newKey, by, floorAtZero)
+	if err == nil {
+		err = settings.expireKeys(ctx, newKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := resetLeaderboard(ctx, settings, namespace, key, result.value.(int64)); err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+	}
+	return result, nil
+}
+
 func IncrementPv(c *gin.Context) {
-	incrMethodCalls("increment_pv")
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "increment_pv")
 
 	namespace := c.Query("namespace")
 	key := c.Query("key")
 	if ok := checkNamespaceAndKey(namespace, key, c); !ok {
 		return
 	}
+	by, ok := checkBy(c)
+	if !ok {
+		return
+	}
 	if ok := isNamespaceValid(namespace, c); !ok {
 		return
 	}
+	if by != 1 {
+		incrementPvBy(namespace, key, by, c)
+		return
+	}
+	if ok := isTokenAllowed(namespace, SCOPE_INCREMENT, c); !ok {
+		return
+	}
 
-	newKey := constructKey(namespace, key)
-	result, err := G_db.Incr(newKey)
+	var result *RedisResult
+	var skipped string
+	counted := false
+	hidden := false
+	settings, err := getSettings(ctx, namespace)
+	if err == nil {
+		hidden = !canRead(settings, namespace, c)
+		skipped, err = checkHit(settings, namespace, key, c)
+	}
+	if err == nil && skipped == "" {
+		result, counted, err = incrementPv(ctx, settings, namespace, key, getVisitorId(c))
+	} else if err == nil && !hidden {
+		result, err = getPvValue(ctx, namespace, key)
+	}
+	// the hit of a private namespace is counted, but nothing is told
+	if hidden {
+		respondHidden(err, c)
+		return
+	}
 	if err != nil {
-		G_logger.Warn(err)
-		errMsg := ErrorMessage{
-			Code:   5001,
-			ErrMsg: "internal error",
-		}
-		c.JSON(http.StatusInternalServerError, errMsg)
+		respondPvError(err, c)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "incr key successfully",
+	}
+	if skipped != "" {
+		errMsg.ErrMsg = skipped
+	} else if !counted {
+		errMsg.ErrMsg = "duplicate hit in the dedup window, not counted"
+	}
+	errMsg.Data = append(errMsg.Data, Data{Key: result.key, Value: result.value})
+	errMsg.Data = append(errMsg.Data, Data{Key: "counted", Value: counted})
+	c.JSON(http.StatusOK, errMsg)
+}
+
+// incrementing by more than 1 needs the reset scope, and skips the bot
+// filter and the dedup window
+func incrementPvBy(namespace, key string, by int64, c *gin.Context) {
+	ctx := c.Request.Context()
+	if ok := isAuthorized(namespace, SCOPE_RESET, c); !ok {
+		return
+	}
+	settings, err := getSettings(ctx, namespace)
+	if err != nil {
+		respondPvError(err, c)
+		return
+	}
+	result, err := adjustPv(ctx, namespace, key, by, false)
+	if !canRead(settings, namespace, c) {
+		respondHidden(err, c)
+		return
+	}
+	if err != nil {
+		respondPvError(err, c)
 		return
 	}
 	errMsg := ErrorMessage{
@@ -282,38 +448,73 @@ func IncrementPv(c *gin.Context) {
 		ErrMsg: "incr key successfully",
 	}
 	errMsg.Data = append(errMsg.Data, Data{Key: result.key, Value: result.value})
+	errMsg.Data = append(errMsg.Data, Data{Key: "counted", Value: true})
+	c.JSON(http.StatusOK, errMsg)
+}
+
+func DecrementPv(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "decrement_pv")
+
+	namespace := c.Query("namespace")
+	key := c.Query("key")
+	if ok := checkNamespaceAndKey(namespace, key, c); !ok {
+		return
+	}
+	by, ok := checkBy(c)
+	if !ok {
+		return
+	}
+	if ok := isAuthorized(namespace, SCOPE_RESET, c); !ok {
+		return
+	}
+
+	result, err := adjustPv(ctx, namespace, key, -by, c.Query("floor") == "1")
+	if err != nil {
+		respondPvError(err, c)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "decr key successfully",
+	}
+	errMsg.Data = append(errMsg.Data, Data{Key: result.key, Value: result.value})
 	c.JSON(http.StatusOK, errMsg)
 }
 
 func ResetPv(c *gin.Context) {
-	incrMethodCalls("reset_pv")
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "reset_pv")
 
 	namespace := c.Query("namespace")
-	secret := c.Query("secret")
 	key := c.Query("key")
 	value := c.Query("value")
-	if ok := checkNamespaceSecretKeyValue(namespace, secret, key, value, c); !ok {
+	if ok := checkNamespaceKeyValue(namespace, key, value, c); !ok {
 		return
 	}
-
-	if ok := checkAuthentication(namespace, secret); !ok {
-		errMsg := ErrorMessage{
-			Code:   4002,
-			ErrMsg: "authentication failed",
-		}
-		c.JSON(http.StatusBadRequest, errMsg)
+	if ok := isAuthorized(namespace, SCOPE_RESET, c); !ok {
 		return
 	}
 
 	newKey := constructKey(namespace, key)
-	if err := G_db.Set(newKey, value, true); err != nil {
-		errMsg := ErrorMessage{
-			Code:   5001,
-			ErrMsg: "internal error",
-		}
-		c.JSON(http.StatusInternalServerError, errMsg)
+	settings, err := getSettings(ctx, namespace)
+	if err == nil {
+		err = settings.checkKeyQuota(ctx, namespace, newKey)
+	}
+	if err == nil {
+		err = G_db.Set(ctx, newKey, value, true)
+	}
+	if err == nil {
+		err = settings.expireKeys(ctx, newKey)
+	}
+	if err != nil {
+		respondPvError(err, c)
 		return
 	}
+	score, _ := strconv.ParseInt(value, 10, 64)
+	if err := resetLeaderboard(ctx, settings, namespace, key, score); err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+	}
 	errMsg := ErrorMessage{
 		Code:   0,
 		ErrMsg: "reset key successfully",
@@ -322,26 +523,20 @@ func ResetPv(c *gin.Context) {
 }
 
 func DeletePv(c *gin.Context) {
-	incrMethodCalls("delete_pv")
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "delete_pv")
 
 	namespace := c.Query("namespace")
-	secret := c.Query("secret")
 	key := c.Query("key")
-	if ok := checkNamespaceSecretKey(namespace, secret, key, c); !ok {
+	if ok := checkNamespaceAndKey(namespace, key, c); !ok {
 		return
 	}
-
-	if ok := checkAuthentication(namespace, secret); !ok {
-		errMsg := ErrorMessage{
-			Code:   4002,
-			ErrMsg: "authentication failed",
-		}
-		c.JSON(http.StatusBadRequest, errMsg)
+	if ok := isAuthorized(namespace, SCOPE_DELETE, c); !ok {
 		return
 	}
 
 	newKey := constructKey(namespace, key)
-	cnt, err := G_db.Delete(newKey)
+	cnt, err := G_db.Delete(ctx, newKey)
 	if err != nil {
 		errMsg := ErrorMessage{
 			Code:   5001,
@@ -350,6 +545,9 @@ func DeletePv(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, errMsg)
 		return
 	}
+	if err := removeFromLeaderboard(ctx, namespace, key, time.Now()); err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+	}
 	errMsg := ErrorMessage{
 		Code:   0,
 		ErrMsg: fmt.Sprintf("delete %d keys successfully", cnt),
@@ -358,7 +556,8 @@ func DeletePv(c *gin.Context) {
 }
 
 func CountNamespaces(c *gin.Context) {
-	allKeys, err := G_db.GetPrefixMatchKeys("namespace@*")
+	ctx := c.Request.Context()
+	allKeys, err := G_db.GetPrefixMatchKeys(ctx, "namespace@*")
 	if err != nil {
 		errMsg := ErrorMessage{
 			Code:   5001,
@@ -378,7 +577,8 @@ func CountNamespaces(c *gin.Context) {
 }
 
 func CountKeys(c *gin.Context) {
-	allKeys, err := G_db.GetPrefixMatchKeys("key@*")
+	ctx := c.Request.Context()
+	allKeys, err := G_db.GetPrefixMatchKeys(ctx, "key@*")
 	if err != nil {
 		errMsg := ErrorMessage{
 			Code:   5001,
@@ -398,7 +598,8 @@ func CountKeys(c *gin.Context) {
 }
 
 func CountRequests(c *gin.Context) {
-	allKeys, err := G_db.GetPrefixMatchKeys("call@*")
+	ctx := c.Request.Context()
+	allKeys, err := G_db.GetPrefixMatchKeys(ctx, "call@*")
 	if err != nil {
 		errMsg := ErrorMessage{
 			Code:   5001,
@@ -409,9 +610,9 @@ func CountRequests(c *gin.Context) {
 	}
 	requestCount := 0
 	for _, key := range allKeys {
-		result, err := G_db.Get(key)
+		result, err := G_db.Get(ctx, key)
 		if err != nil {
-			G_logger.Errorf("get key %s failed. err: %v", key, err)
+			G_logger.WithContext(ctx).Errorf("get key %s failed. err: %v", key, err)
 			continue
 		}
 		if value, err := strconv.Atoi(result.value.(string)); err == nil {
@@ -428,29 +629,78 @@ func CountRequests(c *gin.Context) {
 	c.JSON(http.StatusOK, errMsg)
 }
 
-func AddRouters(r *gin.Engine, logger *logrus.Logger) {
-	G_db = NewRedisClient(DEFAULT_REDIS_URL, logger)
-	if G_db == nil {
-		panic("get redis client failed")
-	}
+func AddRouters(r *gin.Engine, db Store, logger *logrus.Logger) {
+	G_db = db
 	G_logger = logger
+	filter, err := NewBotFilter(G_config.BotFilter)
+	if err != nil {
+		logger.WithField("component", "bot_filter").Warn(err)
+	}
+	G_botFilter = filter
 
 	// server status
 	r.GET("/ping", func(c *gin.Context) {
 		c.String(http.StatusOK, "pong")
 	})
 
+	AddMetricsRouter(r, G_config.Metrics)
+
 	// api for PV
 	r.GET("/pv/get", GetPv)
 
+	r.GET("/pv/get/batch", GetPvBatch)
+
+	r.POST("/pv/get/batch", GetPvBatch)
+
 	r.POST("/pv/create", CreatePv)
 
+	// lifecycle of namespace, with the secret or an admin token
+	r.POST("/pv/namespace/delete", DeleteNamespace)
+
+	r.GET("/pv/namespace", GetNamespaceSettings)
+
+	r.PUT("/pv/namespace", UpdateNamespaceSettings)
+
+	r.POST("/pv/namespace/rename", RenameNamespace)
+
+	r.POST("/pv/namespace/rotate", RotateSecret)
+
 	r.POST("/pv/increment", IncrementPv)
 
+	r.POST("/pv/increment/batch", IncrementPvBatch)
+
+	r.POST("/pv/decrement", DecrementPv)
+
 	r.POST("/pv/reset", ResetPv)
 
 	r.POST("/pv/delete", DeletePv)
 
+	r.GET("/pv/history", GetPvHistory)
+
+	r.GET("/pv/top", GetPvTop)
+
+	r.POST("/pv/dedup", SetPvDedup)
+
+	// increments filtered as bots
+	r.GET("/pv/bot", GetBotPv)
+
+	// api tokens of namespace, managed with the secret or an admin token
+	r.POST("/pv/tokens/create", CreateToken)
+
+	r.GET("/pv/tokens/list", ListTokens)
+
+	r.POST("/pv/tokens/revoke", RevokeToken)
+
+	// badge, increment PV and render
+	r.GET("/badge/pv.svg", GetPvBadge)
+
+	// api for UV
+	r.GET("/uv/get", GetUv)
+
+	r.POST("/uv/increment", IncrementUv)
+
+	r.POST("/uv/reset", ResetUv)
+
 	// statistics API
 	r.GET("/pv/statistics/count-namespaces", CountNamespaces)
 
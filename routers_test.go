@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto/md5"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -14,13 +13,32 @@ import (
 
 func MockRouters() *gin.Engine {
 	r := gin.Default()
-	logger := NewLogger()
-	r.Use(LoggerMiddleware(logger))
+	logger := MockNewLogger()
+	r.Use(RequestIDMiddleware())
+	r.Use(LoggerMiddleware(logger, nil))
 
-	AddRouters(r, logger)
+	AddRouters(r, MockNewRedisClient(), logger)
 	return r
 }
 
+// MockRequest serves one request by router, the response is also decoded
+// into ErrorMessage if it's one
+func MockRequest(router http.Handler, method, url string, headers map[string]string, body string) (*httptest.ResponseRecorder, ErrorMessage) {
+	var errMsg ErrorMessage
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	router.ServeHTTP(w, req)
+	_ = json.Unmarshal(w.Body.Bytes(), &errMsg)
+	return w, errMsg
+}
+
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
 func TestIsInt(t *testing.T) {
 	if !isInt("123") {
 		t.Fail()
@@ -36,16 +54,6 @@ func TestConstructKey(t *testing.T) {
 	}
 }
 
-func TestGenerateSecret(t *testing.T) {
-	namespace := "hello"
-	secret := "world"
-	encryptSecret, err := generateScrect(namespace, secret)
-	realSecret := fmt.Sprintf("%s", md5.Sum([]byte(namespace+secret)))
-	if err != nil || encryptSecret != realSecret {
-		t.Fail()
-	}
-}
-
 func TestPingRoute(t *testing.T) {
 	router := MockRouters()
 	defer CleanLog()
@@ -76,7 +84,8 @@ func TestCreatePv(t *testing.T) {
 	req, _ = http.NewRequest("POST", "/pv/create?namespace=test", nil)
 	router.ServeHTTP(w, req)
 
-	if w.Code != 200 || !strings.Contains(w.Body.String(), "successfully") {
+	// the hash of secret should never be returned
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "successfully") || strings.Contains(w.Body.String(), "argon2id") {
 		fmt.Println(w.Body.String())
 		t.Fail()
 	}
@@ -113,7 +122,7 @@ func TestIncrement(t *testing.T) {
 	if w.Code != 200 || err != nil {
 		t.Fail()
 	}
-	if errMsg.Code != 0 || len(errMsg.Data) != 1 {
+	if errMsg.Code != 0 || len(errMsg.Data) != 2 || errMsg.Data[1].Value != true {
 		t.Fail()
 	}
 
@@ -127,7 +136,7 @@ func TestIncrement(t *testing.T) {
 	if w.Code != 200 || err != nil {
 		t.Fail()
 	}
-	if errMsg.Code != 0 || len(errMsg.Data) != 1 {
+	if errMsg.Code != 0 || len(errMsg.Data) != 2 || errMsg.Data[1].Value != true {
 		t.Fail()
 	}
 }
@@ -188,7 +197,7 @@ func TestGetPvTrue(t *testing.T) {
 	}
 
 	// get all keys under namespace
-	err = G_db.Set("key@test@test1", 1, true)
+	err = G_db.Set(ctx, "key@test@test1", 1, true)
 	if err != nil {
 		t.Fail()
 	}
@@ -238,7 +247,7 @@ func TestResetPv(t *testing.T) {
 	_ = json.Unmarshal(w.Body.Bytes(), &errMsg)
 	fmt.Println(w.Body.String(), errMsg)
 
-	result, err := G_db.Get("key@test@test")
+	result, err := G_db.Get(ctx, "key@test@test")
 	if w.Code != 200 || err != nil || errMsg.Code != 0 || result.value.(string) != "10" {
 		t.Fail()
 	}
@@ -268,7 +277,7 @@ func TestDeletePv(t *testing.T) {
 		t.Fail()
 	}
 
-	result, err := G_db.Get("key@test@test1")
+	result, err := G_db.Get(ctx, "key@test@test1")
 	if result != nil || err == nil {
 		fmt.Println(err)
 		t.Fail()
@@ -336,12 +345,64 @@ func TestCountRequests(t *testing.T) {
 }
 
 func TestTeardown(t *testing.T) {
-	G_db.Delete("namespace@test")
-	G_db.Delete("key@test@test")
-
-	G_db.Delete("call@create_pv")
-	G_db.Delete("call@get_pv")
-	G_db.Delete("call@reset_pv")
-	G_db.Delete("call@delete_pv")
-	G_db.Delete("call@increment_pv")
+	G_db.Delete(ctx, "namespace@test", "settings@test")
+	G_db.Delete(ctx, "key@test@test")
+
+	G_db.Delete(ctx, "call@create_pv")
+	G_db.Delete(ctx, "call@get_pv")
+	G_db.Delete(ctx, "call@reset_pv")
+	G_db.Delete(ctx, "call@delete_pv")
+	G_db.Delete(ctx, "call@increment_pv")
+}
+
+func TestIncrementByAndDecrement(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/pv/create?namespace=adjusttest&secret=world", nil)
+	router.ServeHTTP(w, req)
+	defer func() {
+		for _, pattern := range []string{"history@adjusttest@*", "top@adjusttest*", "key@adjusttest@*"} {
+			keys, _ := G_db.GetPrefixMatchKeys(ctx, pattern)
+			G_db.Delete(ctx, keys...)
+		}
+		G_db.Delete(ctx, "namespace@adjusttest", "settings@adjusttest", "call@create_pv", "call@increment_pv", "call@decrement_pv")
+	}()
+
+	for _, query := range []string{"by=0", "by=-1", "by=a"} {
+		if w, _ := MockRequest(router, "POST", "/pv/increment?namespace=adjusttest&key=page&"+query, nil, ""); w.Code != 400 {
+			t.Errorf("%s should be invalid", query)
+		}
+	}
+	// by other than 1 needs authentication
+	if w, errMsg := MockRequest(router, "POST", "/pv/increment?namespace=adjusttest&key=page&by=100", nil, ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/increment?namespace=adjusttest&key=page&by=100&secret=world", nil, ""); w.Code != 200 || errMsg.Data[0].Value != float64(100) {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/increment?namespace=adjusttest&key=page&by=1", nil, ""); w.Code != 200 || errMsg.Data[0].Value != float64(101) {
+		t.Fail()
+	}
+
+	if w, errMsg := MockRequest(router, "POST", "/pv/decrement?namespace=adjusttest&key=page", nil, ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/decrement?namespace=adjusttest&key=page&secret=world", nil, ""); w.Code != 200 || errMsg.Data[0].Value != float64(100) {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/decrement?namespace=adjusttest&key=page&secret=world&by=110", nil, ""); w.Code != 200 || errMsg.Data[0].Value != float64(-10) {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/decrement?namespace=adjusttest&key=page&secret=world&by=1&floor=1", nil, ""); w.Code != 200 || errMsg.Data[0].Value != float64(0) {
+		t.Fail()
+	}
+
+	// the leaderboard follows
+	results, err := G_db.ZTop(ctx, []string{constructTopKey("adjusttest")}, 1)
+	if err != nil || len(results) != 1 || results[0].value.(int64) != 0 {
+		fmt.Println(results)
+		t.Fail()
+	}
 }
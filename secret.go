@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+var secretParams = argon2Params{
+	time:    SECRET_ARGON2_TIME,
+	memory:  SECRET_ARGON2_MEMORY,
+	threads: SECRET_ARGON2_THREADS,
+}
+
+// hashSecret hashes secret by argon2id with a random salt, encoded like
+// `$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>`
+func hashSecret(secret string) (string, error) {
+	salt := make([]byte, SECRET_SALT_LENGTH)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt failed. err[%v]", err)
+	}
+	p := secretParams
+	hash := argon2.IDKey([]byte(secret), salt, p.time, p.memory, p.threads, SECRET_KEY_LENGTH)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+func parseSecretHash(hashed string) (p argon2Params, salt, hash []byte, err error) {
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, fmt.Errorf("unknown secret hash")
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version[%s]", parts[2])
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 params[%s]. err[%v]", parts[3], err)
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 salt. err[%v]", err)
+	}
+	if hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 hash. err[%v]", err)
+	}
+	return p, salt, hash, nil
+}
+
+// legacySecret is md5(`namespace@<namespace>` + secret) in raw bytes,
+// which is how secrets were stored before argon2id
+func legacySecret(namespace, secret string) string {
+	sum := md5.Sum([]byte(constructNamespace(namespace) + secret))
+	return string(sum[:])
+}
+
+// isLegacySecret tells whether hashed is made by legacySecret
+func isLegacySecret(hashed string) bool {
+	_, _, _, err := parseSecretHash(hashed)
+	return err != nil
+}
+
+// verifySecret checks secret against the stored hash of namespace,
+// rehash is true if the hash is legacy or made with outdated params
+func verifySecret(namespace, secret, hashed string) (ok bool, rehash bool) {
+	p, salt, hash, err := parseSecretHash(hashed)
+	if err != nil {
+		expected := legacySecret(namespace, secret)
+		ok = subtle.ConstantTimeCompare([]byte(hashed), []byte(expected)) == 1
+		return ok, ok
+	}
+	actual := argon2.IDKey([]byte(secret), salt, p.time, p.memory, p.threads, uint32(len(hash)))
+	ok = subtle.ConstantTimeCompare(hash, actual) == 1
+	return ok, ok && (p != secretParams || len(hash) != SECRET_KEY_LENGTH)
+}
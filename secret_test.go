@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHashSecret(t *testing.T) {
+	hashed, err := hashSecret("world")
+	fmt.Println(hashed)
+	if err != nil || !strings.HasPrefix(hashed, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Fail()
+	}
+	// salt is random
+	another, _ := hashSecret("world")
+	if another == hashed {
+		t.Fail()
+	}
+	if ok, rehash := verifySecret("hello", "world", hashed); !ok || rehash {
+		t.Fail()
+	}
+	if ok, _ := verifySecret("hello", "World", hashed); ok {
+		t.Fail()
+	}
+}
+
+func TestVerifyLegacySecret(t *testing.T) {
+	hashed := legacySecret("hello", "world")
+	if ok, rehash := verifySecret("hello", "world", hashed); !ok || !rehash {
+		t.Fail()
+	}
+	if ok, rehash := verifySecret("hello", "hello", hashed); ok || rehash {
+		t.Fail()
+	}
+	if ok, _ := verifySecret("hello", "world", "$argon2id$v=19$m=1,t=1,p=1$!$!"); ok {
+		t.Fail()
+	}
+}
+
+func TestVerifyOutdatedSecret(t *testing.T) {
+	params := secretParams
+	secretParams.time = 1
+	hashed, _ := hashSecret("world")
+	secretParams = params
+
+	if ok, rehash := verifySecret("hello", "world", hashed); !ok || !rehash {
+		t.Fail()
+	}
+}
+
+func TestUpgradeLegacySecret(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	// namespace created before argon2id
+	_ = G_db.Set(ctx, "namespace@secrettest", legacySecret("secrettest", "world"), false)
+	defer G_db.Delete(ctx, "namespace@secrettest", "key@secrettest@page", "top@secrettest", "call@reset_pv")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/pv/reset?namespace=secrettest&secret=world&key=page&value=1", nil)
+	router.ServeHTTP(w, req)
+
+	var errMsg ErrorMessage
+	err := json.Unmarshal(w.Body.Bytes(), &errMsg)
+	fmt.Println(w.Body.String(), errMsg)
+	if w.Code != 200 || err != nil || errMsg.Code != 0 {
+		t.Fail()
+	}
+
+	result, err := G_db.Get(ctx, "namespace@secrettest")
+	if err != nil || !strings.HasPrefix(result.value.(string), "$argon2id$") {
+		t.Fail()
+	}
+
+	// the secret still works after upgrade
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/pv/reset?namespace=secrettest&secret=world&key=page&value=2", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != 200 {
+		fmt.Println(w.Body.String())
+		t.Fail()
+	}
+}
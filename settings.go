@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	VISIBILITY_PUBLIC  = "public"
+	VISIBILITY_PRIVATE = "private"
+)
+
+var errTooManyKeys = errors.New("too many keys in namespace")
+
+// namespaceSettings is stored as json in `settings@<namespace>`
+type namespaceSettings struct {
+	// contact of the owner, like an email
+	Owner       string `json:"owner"`
+	Description string `json:"description"`
+	// unix seconds, 0 for namespaces created before settings
+	CreatedAt int64 `json:"created_at"`
+	// origins like `https://example.com` allowed to embed the namespace, empty means any
+	AllowedOrigins []string `json:"allowed_origins"`
+	// ttl of the PV and UV keys in seconds, 0 means store.key_ttl
+	KeyTTL int64 `json:"key_ttl"`
+	// dedup window of increments in seconds, 0 means no dedup
+	DedupWindow int64 `json:"dedup_window"`
+	// public or private, reads of a private namespace need the read scope
+	Visibility string `json:"visibility"`
+	// max count of PV keys, 0 means no limit
+	MaxKeys int64 `json:"max_keys"`
+}
+
+func constructSettingsKey(namespace string) string {
+	return fmt.Sprintf("settings@%s", namespace)
+}
+
+func defaultSettings() *namespaceSettings {
+	return &namespaceSettings{
+		AllowedOrigins: []string{},
+		Visibility:     VISIBILITY_PUBLIC,
+	}
+}
+
+// getSettings loads the settings of namespace. Namespaces created before
+// settings get the defaults, with the dedup window set by `/pv/dedup` before
+func getSettings(ctx context.Context, namespace string) (*namespaceSettings, error) {
+	// peek to keep settings without ttl
+	results, err := G_db.BatchPeek(ctx, constructSettingsKey(namespace), constructDedupPolicyKey(namespace))
+	if err != nil {
+		return nil, err
+	}
+	settings := defaultSettings()
+	if content := results[0].value.(string); content != "" {
+		if err = json.Unmarshal([]byte(content), settings); err != nil {
+			return nil, fmt.Errorf("parse settings of namespace[%s] failed. err[%v]", namespace, err)
+		}
+		return settings, nil
+	}
+	settings.DedupWindow, _ = strconv.ParseInt(results[1].value.(string), 10, 64)
+	return settings, nil
+}
+
+func saveSettings(ctx context.Context, namespace string, settings *namespaceSettings) error {
+	content, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("encode settings of namespace[%s] failed. err[%v]", namespace, err)
+	}
+	if err = G_db.Set(ctx, constructSettingsKey(namespace), string(content), false); err != nil {
+		return err
+	}
+	// the legacy dedup window lives in settings from now on
+	_, err = G_db.Delete(ctx, constructDedupPolicyKey(namespace))
+	return err
+}
+
+// normalizeOrigin returns origin like `https://example.com:8080` in lower case
+func normalizeOrigin(origin string) (string, bool) {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// validate checks settings and normalizes the allowed origins
+func (settings *namespaceSettings) validate() error {
+	if len(settings.Owner) > SETTINGS_MAX_TEXT_LENGTH || len(settings.Description) > SETTINGS_MAX_TEXT_LENGTH {
+		return fmt.Errorf("need owner and description no longer than %d", SETTINGS_MAX_TEXT_LENGTH)
+	}
+	if len(settings.AllowedOrigins) > SETTINGS_MAX_ORIGINS {
+		return fmt.Errorf("need no more than %d allowed_origins", SETTINGS_MAX_ORIGINS)
+	}
+	for index, origin := range settings.AllowedOrigins {
+		normalized, ok := normalizeOrigin(origin)
+		if !ok {
+			return fmt.Errorf("need allowed_origins like https://example.com, got %s", origin)
+		}
+		settings.AllowedOrigins[index] = normalized
+	}
+	if settings.KeyTTL < 0 {
+		return fmt.Errorf("need key_ttl in seconds, 0 for the default")
+	}
+	if settings.DedupWindow < 0 || settings.DedupWindow > int64(DEDUP_MAX_WINDOW/time.Second) {
+		return fmt.Errorf("need dedup_window in seconds, between 0 (disable) and %d", int64(DEDUP_MAX_WINDOW/time.Second))
+	}
+	if settings.Visibility != VISIBILITY_PUBLIC && settings.Visibility != VISIBILITY_PRIVATE {
+		return fmt.Errorf("need visibility of public or private")
+	}
+	if settings.MaxKeys < 0 {
+		return fmt.Errorf("need max_keys >= 0, 0 for no limit")
+	}
+	return nil
+}
+
+func (settings *namespaceSettings) dedupWindow() time.Duration {
+	return time.Duration(settings.DedupWindow) * time.Second
+}
+
+// expireKeys applies the key ttl of namespace, after the store has
+// applied store.key_ttl on writes and reads
+func (settings *namespaceSettings) expireKeys(ctx context.Context, keys ...string) error {
+	if settings.KeyTTL <= 0 || len(keys) == 0 {
+		return nil
+	}
+	return G_db.Expire(ctx, time.Duration(settings.KeyTTL)*time.Second, keys...)
+}
+
+// checkKeyQuota fails with errTooManyKeys if creating the missing ones
+// of PV keys would exceed max keys of namespace. It's best effort: keys are
+// counted by one scan per call, only when some of them are new, and requests
+// creating keys at the same time could go a few over. Callers check all the
+// keys of a namespace in a request at once
+func (settings *namespaceSettings) checkKeyQuota(ctx context.Context, namespace string, keys ...string) error {
+	if settings.MaxKeys <= 0 || len(keys) == 0 {
+		return nil
+	}
+	results, err := G_db.BatchPeek(ctx, keys...)
+	if err != nil {
+		return err
+	}
+	missing := make(map[string]bool)
+	for _, item := range results {
+		if item.value.(string) == "" {
+			missing[item.key] = true
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	existing, err := G_db.GetPrefixMatchKeys(ctx, constructKey(escapePattern(namespace), "*"))
+	if err != nil {
+		return err
+	}
+	if int64(len(existing)+len(missing)) > settings.MaxKeys {
+		return errTooManyKeys
+	}
+	return nil
+}
+
+// isReadAllowed checks the credential for reads by the visibility of
+// namespace, and returns the settings for the read. A private namespace
+// looks like it doesn't exist to callers without the read scope, and
+// listing its keys needs the admin scope
+func isReadAllowed(namespace string, listing bool, c *gin.Context) (*namespaceSettings, bool) {
+	ctx := c.Request.Context()
+	settings, err := getSettings(ctx, namespace)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return nil, false
+	}
+	if settings.Visibility == VISIBILITY_PUBLIC {
+		return settings, isTokenAllowed(namespace, SCOPE_READ, c)
+	}
+	if !checkCredential(namespace, SCOPE_READ, c) {
+		respondHidden(nil, c)
+		return nil, false
+	}
+	if listing {
+		return settings, isAuthorized(namespace, SCOPE_ADMIN, c)
+	}
+	return settings, true
+}
+
+// canRead tells whether the caller may see values of namespace,
+// anyone for a public one and the read scope for a private one
+func canRead(settings *namespaceSettings, namespace string, c *gin.Context) bool {
+	return settings.Visibility == VISIBILITY_PUBLIC || checkCredential(namespace, SCOPE_READ, c)
+}
+
+// respondHidden responds the same as isNamespaceValid to callers who can't
+// read a private namespace, whatever happened to the request
+func respondHidden(err error, c *gin.Context) {
+	if err != nil {
+		G_logger.WithContext(c.Request.Context()).Warn(err)
+	}
+	c.JSON(http.StatusBadRequest, "invalid namespace")
+}
+
+// respondPvError responds the error of writing PV keys
+func respondPvError(err error, c *gin.Context) {
+	ctx := c.Request.Context()
+	if errors.Is(err, errTooManyKeys) {
+		errMsg := ErrorMessage{
+			Code:   4001,
+			ErrMsg: "too many keys in this namespace",
+		}
+		c.JSON(http.StatusBadRequest, errMsg)
+		return
+	}
+	G_logger.WithContext(ctx).Warn(err)
+	errMsg := ErrorMessage{
+		Code:   5001,
+		ErrMsg: "internal error",
+	}
+	c.JSON(http.StatusInternalServerError, errMsg)
+}
+
+func GetNamespaceSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "get_settings")
+
+	namespace := c.Query("namespace")
+	if ok := checkNamespace(namespace, c); !ok {
+		return
+	}
+	if ok := isAuthorized(namespace, SCOPE_ADMIN, c); !ok {
+		return
+	}
+
+	settings, err := getSettings(ctx, namespace)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "get settings successfully",
+	}
+	errMsg.Data = append(errMsg.Data, Data{Key: namespace, Value: settings})
+	c.JSON(http.StatusOK, errMsg)
+}
+
+// UpdateNamespaceSettings updates the fields given in the json body,
+// others are kept
+func UpdateNamespaceSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "update_settings")
+
+	namespace := c.Query("namespace")
+	if ok := checkNamespace(namespace, c); !ok {
+		return
+	}
+	if ok := isAuthorized(namespace, SCOPE_ADMIN, c); !ok {
+		return
+	}
+
+	settings, err := getSettings(ctx, namespace)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	createdAt := settings.CreatedAt
+	if err = c.ShouldBindJSON(settings); err != nil {
+		c.JSON(http.StatusBadRequest, "need json body of settings")
+		return
+	}
+	settings.CreatedAt = createdAt
+	if err = settings.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err = saveSettings(ctx, namespace, settings); err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "update settings successfully",
+	}
+	errMsg.Data = append(errMsg.Data, Data{Key: namespace, Value: settings})
+	c.JSON(http.StatusOK, errMsg)
+}
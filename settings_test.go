@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSettingsValidate(t *testing.T) {
+	settings := defaultSettings()
+	settings.AllowedOrigins = []string{"HTTPS://Example.com/", "http://localhost:8080"}
+	if err := settings.validate(); err != nil || settings.AllowedOrigins[0] != "https://example.com" ||
+		settings.AllowedOrigins[1] != "http://localhost:8080" {
+		fmt.Println(err, settings.AllowedOrigins)
+		t.Fail()
+	}
+
+	invalids := []func(s *namespaceSettings){
+		func(s *namespaceSettings) { s.AllowedOrigins = []string{"example.com"} },
+		func(s *namespaceSettings) { s.AllowedOrigins = []string{"https://example.com/page"} },
+		func(s *namespaceSettings) { s.AllowedOrigins = []string{"ftp://example.com"} },
+		func(s *namespaceSettings) { s.KeyTTL = -1 },
+		func(s *namespaceSettings) { s.DedupWindow = 2 * 24 * 60 * 60 },
+		func(s *namespaceSettings) { s.Visibility = "hidden" },
+		func(s *namespaceSettings) { s.MaxKeys = -1 },
+		func(s *namespaceSettings) { s.Description = strings.Repeat("a", SETTINGS_MAX_TEXT_LENGTH+1) },
+	}
+	for index, invalid := range invalids {
+		settings := defaultSettings()
+		invalid(settings)
+		if err := settings.validate(); err == nil {
+			t.Errorf("settings %d should be invalid", index)
+		}
+	}
+}
+
+func TestNamespaceSettings(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	defer func() {
+		for _, pattern := range namespaceKeyPatterns("settingstest") {
+			G_db.DeleteMatchKeys(ctx, pattern)
+		}
+		G_db.Delete(ctx, "call@create_pv", "call@get_settings", "call@update_settings", "call@increment_pv",
+			"call@reset_pv", "call@get_pv", "call@dedup_pv")
+	}()
+
+	settingsOf := func(errMsg ErrorMessage) map[string]interface{} {
+		if len(errMsg.Data) != 1 {
+			return map[string]interface{}{}
+		}
+		return errMsg.Data[0].Value.(map[string]interface{})
+	}
+
+	MockRequest(router, "POST", "/pv/create?namespace=settingstest&secret=world", nil, "")
+
+	if w, errMsg := MockRequest(router, "GET", "/pv/namespace?namespace=settingstest", nil, ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+	w, errMsg := MockRequest(router, "GET", "/pv/namespace?namespace=settingstest&secret=world", nil, "")
+	if settings := settingsOf(errMsg); w.Code != 200 || settings["visibility"] != VISIBILITY_PUBLIC || settings["created_at"] == float64(0) {
+		t.Fail()
+	}
+
+	if w, _ := MockRequest(router, "PUT", "/pv/namespace?namespace=settingstest&secret=world", nil, `{"visibility":"hidden"}`); w.Code != 400 {
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "PUT", "/pv/namespace?namespace=settingstest&secret=world", nil, `not json`); w.Code != 400 {
+		t.Fail()
+	}
+	// fields not given are kept, created_at can't be changed
+	w, errMsg = MockRequest(router, "PUT", "/pv/namespace?namespace=settingstest&secret=world", nil, `{"owner":"me@example.com","key_ttl":60,"max_keys":1,"created_at":1}`)
+	if settings := settingsOf(errMsg); w.Code != 200 || settings["owner"] != "me@example.com" ||
+		settings["visibility"] != VISIBILITY_PUBLIC || settings["created_at"] == float64(1) {
+		t.Fail()
+	}
+
+	// key ttl and max keys
+	if w, _ := MockRequest(router, "POST", "/pv/increment?namespace=settingstest&key=a", nil, ""); w.Code != 200 {
+		t.Fail()
+	}
+	for _, key := range []string{"key@settingstest@a", "top@settingstest"} {
+		if ttl := mockRedis.TTL(key); ttl <= 0 || ttl > time.Minute {
+			t.Errorf("ttl of %s should be 1m, got %v", key, ttl)
+		}
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/increment?namespace=settingstest&key=b", nil, ""); w.Code != 400 || errMsg.Code != 4001 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/reset?namespace=settingstest&key=b&value=1&secret=world", nil, ""); w.Code != 400 || errMsg.Code != 4001 {
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "POST", "/pv/increment?namespace=settingstest&key=a", nil, ""); w.Code != 200 {
+		t.Fail()
+	}
+
+	// private namespace needs the read scope, and looks like it doesn't exist without
+	MockRequest(router, "PUT", "/pv/namespace?namespace=settingstest&secret=world", nil, `{"visibility":"private"}`)
+	if w, errMsg := MockRequest(router, "GET", "/pv/get?namespace=settingstest&key=a", nil, ""); w.Code != 400 || errMsg.Code != 0 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "GET", "/pv/get?namespace=settingstest&key=a&secret=wrong", nil, ""); w.Code != 400 || errMsg.Code != 0 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "GET", "/pv/get?namespace=settingstest&key=a&secret=world", nil, ""); w.Code != 200 || errMsg.Data[0].Value != "2" {
+		t.Fail()
+	}
+
+	// dedup window lives in settings
+	if w, _ := MockRequest(router, "POST", "/pv/dedup?namespace=settingstest&secret=world&window=30m", nil, ""); w.Code != 200 {
+		t.Fail()
+	}
+	if settings, _ := getSettings(ctx, "settingstest"); settings.DedupWindow != 30*60 || settings.Visibility != VISIBILITY_PRIVATE {
+		t.Fail()
+	}
+	// namespaces created before settings get the legacy dedup window
+	G_db.Delete(ctx, constructSettingsKey("settingstest"))
+	_ = G_db.Set(ctx, constructDedupPolicyKey("settingstest"), 60, false)
+	if settings, _ := getSettings(ctx, "settingstest"); settings.DedupWindow != 60 || settings.Visibility != VISIBILITY_PUBLIC {
+		t.Fail()
+	}
+}
+
+func TestPrivateNamespace(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	defer func() {
+		for _, namespace := range []string{"privatetest", "*"} {
+			for _, pattern := range namespaceKeyPatterns(namespace) {
+				G_db.DeleteMatchKeys(ctx, pattern)
+			}
+		}
+		G_db.Delete(ctx, "call@create_pv", "call@update_settings", "call@create_token", "call@increment_pv", "call@get_pv",
+			"call@get_pv_batch", "call@top_pv", "call@increment_uv", "call@get_uv", "call@bot_pv")
+	}()
+
+	MockRequest(router, "POST", "/pv/create?namespace=privatetest&secret=world", nil, "")
+	MockRequest(router, "PUT", "/pv/namespace?namespace=privatetest&secret=world", nil, `{"visibility":"private"}`)
+	_, errMsg := MockRequest(router, "POST", "/pv/tokens/create?namespace=privatetest&secret=world&name=ci&scopes=read", nil, "")
+	if len(errMsg.Data) != 1 {
+		t.Fatal(errMsg)
+	}
+	readToken := errMsg.Data[0].Value.(string)
+	MockRequest(router, "POST", "/pv/increment?namespace=privatetest&key=a", nil, "")
+	MockRequest(router, "POST", "/uv/increment?namespace=privatetest&key=a", nil, "")
+
+	// the same as a namespace that doesn't exist
+	for _, url := range []string{"/pv/get?namespace=privatetest&key=a", "/pv/get?namespace=privatetest",
+		"/uv/get?namespace=privatetest&key=a", "/pv/top?namespace=privatetest"} {
+		w, errMsg := MockRequest(router, "GET", url, nil, "")
+		notExist, notExistErrMsg := MockRequest(router, "GET", strings.Replace(url, "privatetest", "notexisttest", 1), nil, "")
+		if w.Code != 400 || w.Code != notExist.Code || errMsg.Code != notExistErrMsg.Code {
+			t.Errorf("%s should look like it doesn't exist", url)
+		}
+	}
+	w, errMsg := MockRequest(router, "POST", "/pv/get/batch", nil, `{"items": [{"namespace": "privatetest", "key": "a"}]}`)
+	if w.Code != 200 || errMsg.Data[0].Value.(map[string]interface{})["error"] != "invalid namespace" {
+		t.Fail()
+	}
+
+	// the read scope reads keys, but listing them needs the admin scope
+	if w, errMsg := MockRequest(router, "GET", "/pv/get?namespace=privatetest&key=a", bearer(readToken), ""); w.Code != 200 || errMsg.Data[0].Value != "1" {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "GET", "/uv/get?namespace=privatetest&key=a", bearer(readToken), ""); w.Code != 200 || len(errMsg.Data) != 1 {
+		t.Fail()
+	}
+	for _, url := range []string{"/pv/get?namespace=privatetest", "/pv/top?namespace=privatetest"} {
+		if w, errMsg := MockRequest(router, "GET", url, bearer(readToken), ""); w.Code != 400 || errMsg.Code != 4002 {
+			t.Errorf("%s should need the admin scope", url)
+		}
+	}
+	if w, errMsg := MockRequest(router, "GET", "/pv/get?namespace=privatetest&secret=world", nil, ""); w.Code != 200 || len(errMsg.Data) != 1 {
+		t.Fail()
+	}
+	w, errMsg = MockRequest(router, "POST", "/pv/get/batch", bearer(readToken), `{"items": [{"namespace": "privatetest", "key": "a"}]}`)
+	if w.Code != 200 || errMsg.Data[0].Value.(map[string]interface{})["value"] != float64(1) {
+		t.Fail()
+	}
+
+	// increments are counted, but tell nothing without the read scope
+	for _, url := range []string{"/pv/increment?namespace=privatetest&key=b", "/uv/increment?namespace=privatetest&key=b",
+		"/badge/pv.svg?namespace=privatetest&key=c"} {
+		method := "POST"
+		if strings.HasPrefix(url, "/badge") {
+			method = "GET"
+		}
+		w, errMsg := MockRequest(router, method, url, nil, "")
+		notExist, notExistErrMsg := MockRequest(router, method, strings.Replace(url, "privatetest", "notexisttest", 1), nil, "")
+		if w.Code != 400 || w.Code != notExist.Code || errMsg.Code != notExistErrMsg.Code || len(errMsg.Data) != 0 {
+			t.Errorf("%s should look like it doesn't exist", url)
+		}
+	}
+	body := `{"items": [{"namespace": "privatetest", "key": "d"}, {"namespace": "notexisttest", "key": "d"}]}`
+	w, errMsg = MockRequest(router, "POST", "/pv/increment/batch", nil, body)
+	if w.Code != 200 || !reflect.DeepEqual(errMsg.Data[0].Value, errMsg.Data[1].Value) {
+		t.Fail()
+	}
+	for _, key := range []string{"b", "c", "d"} {
+		if _, errMsg := MockRequest(router, "GET", "/pv/get?namespace=privatetest&key="+key, bearer(readToken), ""); len(errMsg.Data) != 1 || errMsg.Data[0].Value != "1" {
+			t.Errorf("key %s should be counted", key)
+		}
+	}
+	if _, errMsg := MockRequest(router, "GET", "/uv/get?namespace=privatetest&key=b", bearer(readToken), ""); len(errMsg.Data) != 1 || errMsg.Data[0].Value != float64(1) {
+		t.Fail()
+	}
+	w, errMsg = MockRequest(router, "POST", "/pv/increment?namespace=privatetest&key=b&secret=world", nil, "")
+	if w.Code != 200 || len(errMsg.Data) != 2 {
+		t.Fail()
+	}
+
+	// a namespace named by a glob doesn't list the keys of others
+	MockRequest(router, "POST", "/pv/create?namespace=*&secret=world", nil, "")
+	MockRequest(router, "POST", "/pv/increment?namespace=*&key=a", nil, "")
+	MockRequest(router, "POST", "/uv/increment?namespace=*&key=a", nil, "")
+	_ = G_db.Set(ctx, constructBotKey("privatetest", "a"), 1, true)
+	_ = G_db.Set(ctx, constructBotKey("*", "a"), 1, true)
+	for _, url := range []string{"/pv/get?namespace=*", "/uv/get?namespace=*", "/pv/bot?namespace=*"} {
+		w, errMsg := MockRequest(router, "GET", url, nil, "")
+		if w.Code != 200 || len(errMsg.Data) != 1 || strings.Contains(w.Body.String(), "privatetest") {
+			t.Errorf("%s should list the keys of namespace * only", url)
+		}
+	}
+}
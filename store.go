@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Store is the storage backend behind all handlers
+type Store interface {
+	// refresh the ttl of key
+	RefreshExpire(ctx context.Context, key string) error
+	// set the ttl of keys, missing keys are skipped
+	Expire(ctx context.Context, ttl time.Duration, keys ...string) error
+	// set key to value, with or without ttl
+	Set(ctx context.Context, key string, value interface{}, use_ttl bool) error
+	// set key to value with ttl only if key doesn't exist, return whether it's set
+	SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error)
+	// get value of key as string, refresh ttl
+	Get(ctx context.Context, key string) (*RedisResult, error)
+	// get values of keys, refresh ttl
+	BatchGet(ctx context.Context, keys ...string) ([]RedisResult, error)
+	// get values of keys without refreshing ttl
+	BatchPeek(ctx context.Context, keys ...string) ([]RedisResult, error)
+	// increment key by 1, return the new value as int64
+	Incr(ctx context.Context, key string) (*RedisResult, error)
+	// increment key by `by` which may be negative, a result below zero is
+	// set to zero if floorAtZero, return the new value as int64
+	IncrBy(ctx context.Context, key string, by int64, floorAtZero bool) (*RedisResult, error)
+	// increment keys by 1, each key with its own ttl
+	BatchIncr(ctx context.Context, keys []string, ttls []time.Duration) ([]RedisResult, error)
+	// increment keys by bys atomically, nothing changes if any key is not an
+	// integer, return the new values as int64
+	BatchIncrBy(ctx context.Context, keys []string, bys []int64) ([]RedisResult, error)
+	// delete keys, return the count of deleted keys
+	Delete(ctx context.Context, keys ...string) (int64, error)
+	// get all keys matching the glob-style pattern
+	GetPrefixMatchKeys(ctx context.Context, pattern string) ([]string, error)
+	// delete all keys matching the glob-style pattern in batches, return the count
+	DeleteMatchKeys(ctx context.Context, pattern string) (int64, error)
+	// rename keys to newKeys atomically, missing keys are skipped,
+	// nothing changes if any of newKeys exists
+	Rename(ctx context.Context, keys []string, newKeys []string) error
+	// add elements to the HyperLogLog, return the new cardinality as int64
+	PfAdd(ctx context.Context, key string, elements ...interface{}) (*RedisResult, error)
+	// get cardinality of the HyperLogLog
+	PfCount(ctx context.Context, key string) (*RedisResult, error)
+	// get cardinality of HyperLogLogs
+	BatchPfCount(ctx context.Context, keys ...string) ([]RedisResult, error)
+	// increment member by `by` in sorted sets, each key with its own ttl
+	BatchZIncrBy(ctx context.Context, keys []string, ttls []time.Duration, member string, by int64) error
+	// set score of member in sorted set
+	ZAdd(ctx context.Context, key string, member string, score int64) error
+	// remove member from sorted sets
+	ZRem(ctx context.Context, keys []string, member string) error
+	// sum scores of sorted sets, return members with the highest scores,
+	// each result is the member and its score as int64
+	ZTop(ctx context.Context, keys []string, limit int) ([]RedisResult, error)
+	// release the backend, flush data if needed
+	Close() error
+}
+
+const (
+	STORE_REDIS  = "redis"
+	STORE_MEMORY = "memory"
+	STORE_BOLT   = "bolt"
+)
+
+func NewStore(config StoreConfig, logger *logrus.Logger) (Store, error) {
+	switch config.Backend {
+	case STORE_REDIS:
+		db := NewRedisStore(config.RedisUrl, config.KeyTTL, logger)
+		if db == nil {
+			return nil, fmt.Errorf("get redis client failed")
+		}
+		return db, nil
+	case STORE_MEMORY:
+		db := NewMemoryStore(config.MemorySnapshotPath, config.MemorySnapshotInterval, config.KeyTTL, logger)
+		if db == nil {
+			return nil, fmt.Errorf("get memory store failed")
+		}
+		return db, nil
+	case STORE_BOLT:
+		db := NewBoltStore(config.BoltFilePath, config.BoltFsync, config.BoltSyncInterval,
+			config.BoltSweepInterval, config.KeyTTL, logger)
+		if db == nil {
+			return nil, fmt.Errorf("get bolt store failed")
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown store backend[%s]", config.Backend)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewStore(t *testing.T) {
+	logger := MockNewLogger()
+	defer CleanLog()
+
+	config := DefaultConfig().Store
+	db, err := NewStore(config, logger)
+	if db == nil || err != nil {
+		t.Fail()
+	}
+
+	config.Backend = STORE_MEMORY
+	db, err = NewStore(config, logger)
+	if db == nil || err != nil {
+		t.Fail()
+	}
+
+	config.Backend = "unknown"
+	db, err = NewStore(config, logger)
+	if db != nil || err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SCOPE_READ      = "read"
+	SCOPE_INCREMENT = "increment"
+	SCOPE_RESET     = "reset"
+	SCOPE_DELETE    = "delete"
+	// admin covers all the scopes above and manages tokens, same as the secret
+	SCOPE_ADMIN = "admin"
+)
+
+var tokenScopes = map[string]bool{
+	SCOPE_READ:      true,
+	SCOPE_INCREMENT: true,
+	SCOPE_RESET:     true,
+	SCOPE_DELETE:    true,
+	SCOPE_ADMIN:     true,
+}
+
+// apiToken is stored as json under `token@<namespace>@<id>`,
+// only the sha256 of the token is kept
+type apiToken struct {
+	Id        string   `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Hash      string   `json:"hash,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+	// unix seconds, 0 means never
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+func (token *apiToken) hasScope(scope string) bool {
+	for _, s := range token.Scopes {
+		if s == scope || s == SCOPE_ADMIN {
+			return true
+		}
+	}
+	return false
+}
+
+func (token *apiToken) isExpired(now time.Time) bool {
+	return token.ExpiresAt != 0 && now.Unix() >= token.ExpiresAt
+}
+
+func constructTokenKey(namespace, id string) string {
+	return fmt.Sprintf("token@%s@%s", namespace, id)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateToken returns the id and the token like `ctr_<id>_<random>`
+func generateToken() (string, string, error) {
+	b := make([]byte, TOKEN_ID_LENGTH+TOKEN_RANDOM_LENGTH)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("generate token failed. err[%v]", err)
+	}
+	id := hex.EncodeToString(b[:TOKEN_ID_LENGTH])
+	return id, fmt.Sprintf("ctr_%s_%s", id, hex.EncodeToString(b[TOKEN_ID_LENGTH:])), nil
+}
+
+// parse the id out of token, return "" if the token is malformed
+func parseTokenId(token string) string {
+	parts := strings.Split(token, "_")
+	if len(parts) != 3 || parts[0] != "ctr" || parts[1] == "" || parts[2] == "" {
+		return ""
+	}
+	return parts[1]
+}
+
+// parse scopes like `read,increment`
+func parseScopes(s string) ([]string, error) {
+	scopes := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, scope := range strings.Split(s, ",") {
+		scope = strings.TrimSpace(scope)
+		if !tokenScopes[scope] {
+			return nil, fmt.Errorf("invalid scope[%s]", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+func getBearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+func checkToken(ctx context.Context, namespace, token, scope string) bool {
+	id := parseTokenId(token)
+	if id == "" {
+		G_logger.WithContext(ctx).Warn(fmt.Errorf("token is malformed"))
+		return false
+	}
+	// peek to keep tokens without ttl, like settings
+	results, err := G_db.BatchPeek(ctx, constructTokenKey(namespace, id))
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		return false
+	}
+	content := results[0].value.(string)
+	if content == "" {
+		G_logger.WithContext(ctx).Warn(fmt.Errorf("token[%s] of namespace[%s] doesn't exist", id, namespace))
+		return false
+	}
+	stored := &apiToken{}
+	if err = json.Unmarshal([]byte(content), stored); err != nil {
+		G_logger.WithContext(ctx).Warnf("decode token[%s] of namespace[%s] failed. err[%v]", id, namespace, err)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(stored.Hash), []byte(hashToken(token))) != 1 {
+		G_logger.WithContext(ctx).Warn(fmt.Errorf("token[%s] is invalid", id))
+		return false
+	}
+	if stored.isExpired(time.Now()) {
+		G_logger.WithContext(ctx).Warn(fmt.Errorf("token[%s] is expired", id))
+		return false
+	}
+	if !stored.hasScope(scope) {
+		G_logger.WithContext(ctx).Warn(fmt.Errorf("token[%s] has no scope[%s]", id, scope))
+		return false
+	}
+	return true
+}
+
+// checkCredential checks the bearer token for scope, or the secret in query
+// which works as an admin token
+func checkCredential(namespace, scope string, c *gin.Context) bool {
+	ctx := c.Request.Context()
+	if token := getBearerToken(c); token != "" {
+		return checkToken(ctx, namespace, token, scope)
+	}
+	if secret := c.Query("secret"); secret != "" {
+		// the secret covers every scope, so argon2id runs once per request
+		// however many scopes are checked
+		verifiedKey := "secret@" + namespace
+		if ok, verified := c.Get(verifiedKey); verified {
+			return ok.(bool)
+		}
+		ok := checkAuthentication(ctx, namespace, secret)
+		c.Set(verifiedKey, ok)
+		return ok
+	}
+	return false
+}
+
+// isAuthorized responds 4002 if the credential isn't valid for scope
+func isAuthorized(namespace, scope string, c *gin.Context) bool {
+	ok := checkCredential(namespace, scope, c)
+	if !ok {
+		errMsg := ErrorMessage{
+			Code:   4002,
+			ErrMsg: "authentication failed",
+		}
+		c.JSON(http.StatusBadRequest, errMsg)
+	}
+	return ok
+}
+
+// isTokenAllowed is for the endpoints open to everyone,
+// a bearer token is optional but must be valid for scope if given
+func isTokenAllowed(namespace, scope string, c *gin.Context) bool {
+	if getBearerToken(c) == "" {
+		return true
+	}
+	return isAuthorized(namespace, scope, c)
+}
+
+func CreateToken(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "create_token")
+
+	namespace := c.Query("namespace")
+	name := c.Query("name")
+	if namespace == "" || strings.Contains(namespace, "@") || name == "" {
+		c.JSON(http.StatusBadRequest, "need namespace without @ and name")
+		return
+	}
+	scopes, err := parseScopes(c.Query("scopes"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, "need scopes of read, increment, reset, delete or admin, separated by comma")
+		return
+	}
+	var ttl time.Duration
+	if s := c.Query("ttl"); s != "" {
+		if ttl, err = time.ParseDuration(s); err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, "need ttl like 720h")
+			return
+		}
+	}
+	if ok := isAuthorized(namespace, SCOPE_ADMIN, c); !ok {
+		return
+	}
+
+	keys, err := G_db.GetPrefixMatchKeys(ctx, constructTokenKey(escapePattern(namespace), "*"))
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	if len(keys) >= TOKEN_MAX_PER_NAMESPACE {
+		errMsg := ErrorMessage{
+			Code:   4001,
+			ErrMsg: fmt.Sprintf("no more than %d tokens in a namespace", TOKEN_MAX_PER_NAMESPACE),
+		}
+		c.JSON(http.StatusBadRequest, errMsg)
+		return
+	}
+
+	id, token, err := generateToken()
+	now := time.Now()
+	stored := apiToken{
+		Id:        id,
+		Name:      name,
+		Scopes:    scopes,
+		Hash:      hashToken(token),
+		CreatedAt: now.Unix(),
+	}
+	if ttl > 0 {
+		stored.ExpiresAt = now.Add(ttl).Unix()
+	}
+	var content []byte
+	if err == nil {
+		content, err = json.Marshal(stored)
+	}
+	if err == nil {
+		err = G_db.Set(ctx, constructTokenKey(namespace, id), string(content), false)
+	}
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	// the token is only returned here, it can't be recovered later
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "create token successfully",
+	}
+	errMsg.Data = append(errMsg.Data, Data{Key: id, Value: token})
+	c.JSON(http.StatusOK, errMsg)
+}
+
+func ListTokens(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "list_tokens")
+
+	namespace := c.Query("namespace")
+	if ok := checkNamespace(namespace, c); !ok {
+		return
+	}
+	if ok := isAuthorized(namespace, SCOPE_ADMIN, c); !ok {
+		return
+	}
+
+	keys, err := G_db.GetPrefixMatchKeys(ctx, constructTokenKey(escapePattern(namespace), "*"))
+	var results []RedisResult
+	if err == nil {
+		sort.Strings(keys)
+		results, err = G_db.BatchPeek(ctx, keys...)
+	}
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "list tokens successfully",
+		Data:   make([]Data, 0),
+	}
+	for _, item := range results {
+		token := apiToken{}
+		if err := json.Unmarshal([]byte(item.value.(string)), &token); err != nil {
+			// expired between scan and get
+			continue
+		}
+		token.Hash = ""
+		errMsg.Data = append(errMsg.Data, Data{Key: token.Id, Value: token})
+	}
+	c.JSON(http.StatusOK, errMsg)
+}
+
+func RevokeToken(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "revoke_token")
+
+	namespace := c.Query("namespace")
+	id := c.Query("id")
+	if namespace == "" || strings.Contains(namespace, "@") || id == "" || strings.Contains(id, "@") {
+		c.JSON(http.StatusBadRequest, "need namespace without @ and id")
+		return
+	}
+	if ok := isAuthorized(namespace, SCOPE_ADMIN, c); !ok {
+		return
+	}
+
+	cnt, err := G_db.Delete(ctx, constructTokenKey(namespace, id))
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	if cnt == 0 {
+		errMsg := ErrorMessage{
+			Code:   4001,
+			ErrMsg: "this token doesn't exist",
+		}
+		c.JSON(http.StatusBadRequest, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "revoke token successfully",
+	}
+	c.JSON(http.StatusOK, errMsg)
+}
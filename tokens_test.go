@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := parseScopes("read, increment,read")
+	if err != nil || len(scopes) != 2 || scopes[0] != SCOPE_READ || scopes[1] != SCOPE_INCREMENT {
+		t.Fail()
+	}
+	for _, s := range []string{"", "write", "read,"} {
+		if _, err := parseScopes(s); err == nil {
+			t.Errorf("scopes %s should be invalid", s)
+		}
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	id, token, err := generateToken()
+	if err != nil || parseTokenId(token) != id || len(id) != TOKEN_ID_LENGTH*2 {
+		t.Fail()
+	}
+	for _, token := range []string{"", "ctr_", "ctr__abc", "abc_def_ghi", "ctr_a_b_c"} {
+		if parseTokenId(token) != "" {
+			t.Errorf("token %s should be malformed", token)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/pv/create?namespace=tokentest&secret=world", nil)
+	router.ServeHTTP(w, req)
+	defer func() {
+		for _, pattern := range []string{"history@tokentest@*", "top@tokentest*", "key@tokentest@*", "token@tokentest@*"} {
+			keys, _ := G_db.GetPrefixMatchKeys(ctx, pattern)
+			G_db.Delete(ctx, keys...)
+		}
+		G_db.Delete(ctx, "namespace@tokentest", "settings@tokentest", "call@create_pv", "call@create_token", "call@list_tokens", "call@revoke_token",
+			"call@increment_pv", "call@get_pv", "call@reset_pv")
+	}()
+
+	createToken := func(query string) string {
+		w, errMsg := MockRequest(router, "POST", "/pv/tokens/create?namespace=tokentest&secret=world&"+query, nil, "")
+		if w.Code != 200 || errMsg.Code != 0 || len(errMsg.Data) != 1 {
+			t.Fatal(errMsg)
+		}
+		return errMsg.Data[0].Value.(string)
+	}
+
+	if w, _ := MockRequest(router, "POST", "/pv/tokens/create?namespace=tokentest&secret=world&name=ci&scopes=write", nil, ""); w.Code != 400 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/tokens/create?namespace=tokentest&secret=hello&name=ci&scopes=read", nil, ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+
+	readToken := createToken("name=ci&scopes=read")
+	incrToken := createToken("name=frontend&scopes=increment")
+	adminToken := createToken("name=ops&scopes=admin&ttl=1h")
+
+	// anonymous increment is still open
+	if w, _ := MockRequest(router, "POST", "/pv/increment?namespace=tokentest&key=page", nil, ""); w.Code != 200 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/increment?namespace=tokentest&key=page", bearer(readToken), ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "POST", "/pv/increment?namespace=tokentest&key=page", bearer(incrToken), ""); w.Code != 200 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "GET", "/pv/get?namespace=tokentest&key=page", bearer(readToken), ""); w.Code != 200 || errMsg.Data[0].Value != "2" {
+		t.Fail()
+	}
+
+	// secret is no longer required with a token
+	if w, errMsg := MockRequest(router, "POST", "/pv/reset?namespace=tokentest&key=page&value=10", bearer(incrToken), ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/reset?namespace=tokentest&key=page&value=10", nil, ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "POST", "/pv/reset?namespace=tokentest&key=page&value=10", bearer(adminToken), ""); w.Code != 200 {
+		t.Fail()
+	}
+	// token of a namespace doesn't work for another one
+	if w, _ := MockRequest(router, "POST", "/pv/reset?namespace=test&key=page&value=10", bearer(adminToken), ""); w.Code != 400 {
+		t.Fail()
+	}
+
+	// tokens are listed without hash
+	w, errMsg := MockRequest(router, "GET", "/pv/tokens/list?namespace=tokentest", bearer(adminToken), "")
+	if w.Code != 200 || len(errMsg.Data) != 3 {
+		t.Fail()
+	}
+	for _, item := range errMsg.Data {
+		token := item.Value.(map[string]interface{})
+		if _, ok := token["hash"]; ok || token["id"] != item.Key {
+			t.Fail()
+		}
+	}
+	if w, _ := MockRequest(router, "GET", "/pv/tokens/list?namespace=tokentest", bearer(readToken), ""); w.Code != 400 {
+		t.Fail()
+	}
+
+	if w, _ := MockRequest(router, "POST", "/pv/tokens/revoke?namespace=tokentest&id="+parseTokenId(readToken), bearer(adminToken), ""); w.Code != 200 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "POST", "/pv/tokens/revoke?namespace=tokentest&id="+parseTokenId(readToken), bearer(adminToken), ""); w.Code != 400 || errMsg.Code != 4001 {
+		t.Fail()
+	}
+	if w, _ := MockRequest(router, "GET", "/pv/get?namespace=tokentest&key=page", bearer(readToken), ""); w.Code != 400 {
+		t.Fail()
+	}
+	// a valid id with a wrong random part
+	if w, _ := MockRequest(router, "GET", "/pv/get?namespace=tokentest&key=page", bearer(incrToken[:len(incrToken)-1]+"x"), ""); w.Code != 400 {
+		t.Fail()
+	}
+
+	// expired token
+	result, _ := G_db.Get(ctx, constructTokenKey("tokentest", parseTokenId(adminToken)))
+	stored := apiToken{}
+	_ = json.Unmarshal([]byte(result.value.(string)), &stored)
+	stored.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	content, _ := json.Marshal(stored)
+	_ = G_db.Set(ctx, result.key, string(content), false)
+	if w, errMsg := MockRequest(router, "POST", "/pv/reset?namespace=tokentest&key=page&value=1", bearer(adminToken), ""); w.Code != 400 || !strings.Contains(errMsg.ErrMsg, "authentication") {
+		t.Fail()
+	}
+}
+
+func TestCheckCredentialOnce(t *testing.T) {
+	defer CleanLog()
+	hashed, _ := hashSecret("world")
+	G_db.Set(ctx, "namespace@credentialtest", hashed, false)
+	defer G_db.Delete(ctx, "namespace@credentialtest")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/pv/get?namespace=credentialtest&secret=world", nil)
+	if !checkCredential("credentialtest", SCOPE_READ, c) {
+		t.Fail()
+	}
+	// verified already, the namespace isn't read again
+	G_db.Delete(ctx, "namespace@credentialtest")
+	if !checkCredential("credentialtest", SCOPE_ADMIN, c) {
+		t.Fail()
+	}
+}
+
+func TestTokenOfExpiredNamespace(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+	defer func() {
+		for _, pattern := range namespaceKeyPatterns("tokenexpiretest") {
+			G_db.DeleteMatchKeys(ctx, pattern)
+		}
+		G_db.Delete(ctx, "call@create_pv", "call@create_token", "call@list_tokens")
+	}()
+
+	MockRequest(router, "POST", "/pv/create?namespace=tokenexpiretest&secret=world", nil, "")
+	_, errMsg := MockRequest(router, "POST", "/pv/tokens/create?namespace=tokenexpiretest&secret=world&name=ci&scopes=admin", nil, "")
+	if len(errMsg.Data) != 1 {
+		t.Fatal(errMsg)
+	}
+	token := errMsg.Data[0].Value.(string)
+	if w, _ := MockRequest(router, "GET", "/pv/tokens/list?namespace=tokenexpiretest", bearer(token), ""); w.Code != 200 {
+		t.Fail()
+	}
+	// using the token doesn't give it a ttl
+	if ttl := mockRedis.TTL(constructTokenKey("tokenexpiretest", parseTokenId(token))); ttl != 0 {
+		t.Errorf("token should have no ttl, got %v", ttl)
+	}
+
+	// the token of the old owner doesn't work once the namespace expires
+	// and someone else creates it again
+	G_db.Delete(ctx, "namespace@tokenexpiretest")
+	MockRequest(router, "POST", "/pv/create?namespace=tokenexpiretest&secret=other", nil, "")
+	if w, errMsg := MockRequest(router, "GET", "/pv/tokens/list?namespace=tokenexpiretest", bearer(token), ""); w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+	if w, errMsg := MockRequest(router, "GET", "/pv/tokens/list?namespace=tokenexpiretest&secret=other", nil, ""); w.Code != 200 || len(errMsg.Data) != 0 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TRACING_NONE   = "none"
+	TRACING_OTLP   = "otlp"
+	TRACING_STDOUT = "stdout"
+	TRACING_FILE   = "file"
+)
+
+// the global tracer delegates to the provider set by InitTracing,
+// it's a noop before that
+var tracer = otel.Tracer("github.com/plantree/counter")
+
+// G_tracerProvider is nil unless an exporter is configured
+var G_tracerProvider *sdktrace.TracerProvider
+
+// InitTracing sets the global tracer provider with the exporter of config,
+// and the W3C trace context propagator in any case
+func InitTracing(config TracingConfig) (*sdktrace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case TRACING_NONE:
+		return nil, nil
+	case TRACING_OTLP:
+		// the endpoint and headers could be set by OTEL_EXPORTER_OTLP_* too
+		options := make([]otlptracehttp.Option, 0)
+		if config.OtlpEndpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(config.OtlpEndpoint))
+		}
+		if config.OtlpInsecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	case TRACING_STDOUT:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TRACING_FILE:
+		var f *os.File
+		f, err = os.OpenFile(config.FilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err == nil {
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		}
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter[%s]", config.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create tracing exporter[%s] failed. err[%v]", config.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(config.ServiceName))),
+		// traces from upstream keep their decision
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider, nil
+}
+
+// TracingMiddleware starts a server span for each request, as a child of
+// the trace context in headers if any. Handlers pass it on with
+// `c.Request.Context()`
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = METRICS_UNMATCHED_ROUTE
+		}
+		attrs := semconv.HTTPServerAttributesFromHTTPRequest("counter", route, c.Request)
+		// the target has secrets in query like the access log
+		for i, attr := range attrs {
+			if attr.Key == semconv.HTTPTargetKey {
+				attrs[i] = semconv.HTTPTargetKey.String(redactUri(attr.Value.AsString()))
+			}
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+	}
+}
+
+// tracedStore starts a span for every method of the store
+type tracedStore struct {
+	Store
+}
+
+var _ Store = (*tracedStore)(nil)
+
+func NewTracedStore(db Store) Store {
+	return &tracedStore{Store: db}
+}
+
+// kinds of keys like `<kind>@<namespace>@...`
+var namespacedKeyKinds = map[string]bool{
+	"namespace": true,
+	"settings":  true,
+	"key":       true,
+	"uv":        true,
+	"bot":       true,
+	"top":       true,
+	"history":   true,
+	"dedup":     true,
+	"token":     true,
+}
+
+// keyAttributes describes key by its kind and namespace only, the rest like
+// visitors of dedup keys and ips of rate limit keys never goes to spans
+func keyAttributes(key string) []attribute.KeyValue {
+	parts := strings.SplitN(key, "@", 3)
+	attrs := []attribute.KeyValue{attribute.String("counter.key_kind", parts[0])}
+	if len(parts) > 1 && namespacedKeyKinds[parts[0]] {
+		attrs = append(attrs, attribute.String("counter.namespace", parts[1]))
+	}
+	return attrs
+}
+
+func startStoreSpan(ctx context.Context, method string, keys ...string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{semconv.DBOperationKey.String(method)}
+	if len(keys) == 1 {
+		attrs = append(attrs, keyAttributes(keys[0])...)
+	} else {
+		attrs = append(attrs, attribute.Int("counter.keys", len(keys)))
+	}
+	return tracer.Start(ctx, "store."+method, trace.WithAttributes(attrs...))
+}
+
+// endStoreSpan ends span with err, a missing key is not an error
+func endStoreSpan(span trace.Span, err error) {
+	if err != nil && !strings.Contains(err.Error(), "does not exist") {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (db *tracedStore) RefreshExpire(ctx context.Context, key string) error {
+	ctx, span := startStoreSpan(ctx, "RefreshExpire", key)
+	err := db.Store.RefreshExpire(ctx, key)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (db *tracedStore) Expire(ctx context.Context, ttl time.Duration, keys ...string) error {
+	ctx, span := startStoreSpan(ctx, "Expire", keys...)
+	err := db.Store.Expire(ctx, ttl, keys...)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (db *tracedStore) Set(ctx context.Context, key string, value interface{}, use_ttl bool) error {
+	ctx, span := startStoreSpan(ctx, "Set", key)
+	err := db.Store.Set(ctx, key, value, use_ttl)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (db *tracedStore) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	ctx, span := startStoreSpan(ctx, "SetNX", key)
+	ok, err := db.Store.SetNX(ctx, key, value, ttl)
+	endStoreSpan(span, err)
+	return ok, err
+}
+
+func (db *tracedStore) Get(ctx context.Context, key string) (*RedisResult, error) {
+	ctx, span := startStoreSpan(ctx, "Get", key)
+	result, err := db.Store.Get(ctx, key)
+	endStoreSpan(span, err)
+	return result, err
+}
+
+func (db *tracedStore) BatchGet(ctx context.Context, keys ...string) ([]RedisResult, error) {
+	ctx, span := startStoreSpan(ctx, "BatchGet", keys...)
+	results, err := db.Store.BatchGet(ctx, keys...)
+	endStoreSpan(span, err)
+	return results, err
+}
+
+func (db *tracedStore) BatchPeek(ctx context.Context, keys ...string) ([]RedisResult, error) {
+	ctx, span := startStoreSpan(ctx, "BatchPeek", keys...)
+	results, err := db.Store.BatchPeek(ctx, keys...)
+	endStoreSpan(span, err)
+	return results, err
+}
+
+func (db *tracedStore) Incr(ctx context.Context, key string) (*RedisResult, error) {
+	ctx, span := startStoreSpan(ctx, "Incr", key)
+	result, err := db.Store.Incr(ctx, key)
+	endStoreSpan(span, err)
+	return result, err
+}
+
+func (db *tracedStore) IncrBy(ctx context.Context, key string, by int64, floorAtZero bool) (*RedisResult, error) {
+	ctx, span := startStoreSpan(ctx, "IncrBy", key)
+	result, err := db.Store.IncrBy(ctx, key, by, floorAtZero)
+	endStoreSpan(span, err)
+	return result, err
+}
+
+func (db *tracedStore) BatchIncr(ctx context.Context, keys []string, ttls []time.Duration) ([]RedisResult, error) {
+	ctx, span := startStoreSpan(ctx, "BatchIncr", keys...)
+	results, err := db.Store.BatchIncr(ctx, keys, ttls)
+	endStoreSpan(span, err)
+	return results, err
+}
+
+func (db *tracedStore) BatchIncrBy(ctx context.Context, keys []string, bys []int64) ([]RedisResult, error) {
+	ctx, span := startStoreSpan(ctx, "BatchIncrBy", keys...)
+	results, err := db.Store.BatchIncrBy(ctx, keys, bys)
+	endStoreSpan(span, err)
+	return results, err
+}
+
+func (db *tracedStore) Delete(ctx context.Context, keys ...string) (int64, error) {
+	ctx, span := startStoreSpan(ctx, "Delete", keys...)
+	cnt, err := db.Store.Delete(ctx, keys...)
+	endStoreSpan(span, err)
+	return cnt, err
+}
+
+func (db *tracedStore) GetPrefixMatchKeys(ctx context.Context, pattern string) ([]string, error) {
+	ctx, span := startStoreSpan(ctx, "GetPrefixMatchKeys", pattern)
+	keys, err := db.Store.GetPrefixMatchKeys(ctx, pattern)
+	endStoreSpan(span, err)
+	return keys, err
+}
+
+func (db *tracedStore) DeleteMatchKeys(ctx context.Context, pattern string) (int64, error) {
+	ctx, span := startStoreSpan(ctx, "DeleteMatchKeys", pattern)
+	cnt, err := db.Store.DeleteMatchKeys(ctx, pattern)
+	endStoreSpan(span, err)
+	return cnt, err
+}
+
+func (db *tracedStore) Rename(ctx context.Context, keys []string, newKeys []string) error {
+	ctx, span := startStoreSpan(ctx, "Rename", keys...)
+	err := db.Store.Rename(ctx, keys, newKeys)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (db *tracedStore) PfAdd(ctx context.Context, key string, elements ...interface{}) (*RedisResult, error) {
+	ctx, span := startStoreSpan(ctx, "PfAdd", key)
+	result, err := db.Store.PfAdd(ctx, key, elements...)
+	endStoreSpan(span, err)
+	return result, err
+}
+
+func (db *tracedStore) PfCount(ctx context.Context, key string) (*RedisResult, error) {
+	ctx, span := startStoreSpan(ctx, "PfCount", key)
+	result, err := db.Store.PfCount(ctx, key)
+	endStoreSpan(span, err)
+	return result, err
+}
+
+func (db *tracedStore) BatchPfCount(ctx context.Context, keys ...string) ([]RedisResult, error) {
+	ctx, span := startStoreSpan(ctx, "BatchPfCount", keys...)
+	results, err := db.Store.BatchPfCount(ctx, keys...)
+	endStoreSpan(span, err)
+	return results, err
+}
+
+func (db *tracedStore) BatchZIncrBy(ctx context.Context, keys []string, ttls []time.Duration, member string, by int64) error {
+	ctx, span := startStoreSpan(ctx, "BatchZIncrBy", keys...)
+	err := db.Store.BatchZIncrBy(ctx, keys, ttls, member, by)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (db *tracedStore) ZAdd(ctx context.Context, key string, member string, score int64) error {
+	ctx, span := startStoreSpan(ctx, "ZAdd", key)
+	err := db.Store.ZAdd(ctx, key, member, score)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (db *tracedStore) ZRem(ctx context.Context, keys []string, member string) error {
+	ctx, span := startStoreSpan(ctx, "ZRem", keys...)
+	err := db.Store.ZRem(ctx, keys, member)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (db *tracedStore) ZTop(ctx context.Context, keys []string, limit int) ([]RedisResult, error) {
+	ctx, span := startStoreSpan(ctx, "ZTop", keys...)
+	results, err := db.Store.ZTop(ctx, keys, limit)
+	endStoreSpan(span, err)
+	return results, err
+}
+
+// redisTracingHook starts a client span for every command and pipeline,
+// the arguments are left out as they carry keys and visitor ids
+type redisTracingHook struct{}
+
+var _ redis.Hook = redisTracingHook{}
+
+func (redisTracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = tracer.Start(ctx, "redis."+strings.ToLower(cmd.Name()), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationKey.String(strings.ToLower(cmd.Name()))))
+	return ctx, nil
+}
+
+func (redisTracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endRedisSpan(trace.SpanFromContext(ctx), cmd.Err())
+	return nil
+}
+
+func (redisTracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, cmd := range cmds {
+		if name := strings.ToLower(cmd.Name()); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	ctx, _ = tracer.Start(ctx, "redis.pipeline", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationKey.String(strings.Join(names, " "))))
+	return ctx, nil
+}
+
+func (redisTracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && !errors.Is(cmdErr, redis.Nil) {
+			err = cmdErr
+			break
+		}
+	}
+	endRedisSpan(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+func endRedisSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, redis.Nil) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// it goes first, as the global tracer sticks to the first provider set
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer provider.Shutdown(context.Background())
+	if _, err := InitTracing(TracingConfig{Exporter: TRACING_NONE}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.Default()
+	logger := MockNewLogger()
+	router.Use(TracingMiddleware())
+	AddRouters(router, NewTracedStore(MockNewRedisClient()), logger)
+	defer CleanLog()
+
+	defer func() {
+		for _, pattern := range namespaceKeyPatterns("tracetest") {
+			G_db.DeleteMatchKeys(ctx, pattern)
+		}
+		G_db.Delete(ctx, "call@create_pv", "call@increment_pv")
+	}()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/pv/create?namespace=tracetest&secret=world", nil)
+	req.RequestURI = req.URL.RequestURI()
+	router.ServeHTTP(w, req)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/pv/increment?namespace=tracetest&key=page", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fail()
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() == "4bf92f3577b34da6a3ce929d0e0e4736" {
+			spans[span.Name()] = span
+		}
+	}
+	server, ok := spans["POST /pv/increment"]
+	if !ok || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatal("server span should continue the trace in headers")
+	}
+	incr, ok := spans["store.Incr"]
+	if !ok || incr.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatal("store span should be a child of the server span")
+	}
+	found := false
+	for _, span := range recorder.Ended() {
+		if strings.HasPrefix(span.Name(), "redis.") && span.Parent().SpanID() == incr.SpanContext().SpanID() {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("redis span should be a child of the store span")
+	}
+	// keys like dedup@<namespace>@<key>@<visitor> are never recorded
+	for _, span := range recorder.Ended() {
+		for _, attr := range span.Attributes() {
+			if strings.HasPrefix(span.Name(), "store.") && strings.Contains(attr.Value.Emit(), "@") {
+				t.Errorf("span %s should not record key %s", span.Name(), attr.Value.Emit())
+			}
+			if strings.Contains(attr.Value.Emit(), "world") {
+				t.Errorf("span %s should not record the secret in %s", span.Name(), attr.Key)
+			}
+		}
+	}
+}
+
+func TestKeyAttributes(t *testing.T) {
+	for key, expected := range map[string]string{
+		"dedup@ns@page@visitor":     "counter.key_kind=dedup,counter.namespace=ns",
+		"key@ns@page":               "counter.key_kind=key,counter.namespace=ns",
+		"ratelimit@ip@10.0.0.1@123": "counter.key_kind=ratelimit",
+		"call@get_pv":               "counter.key_kind=call",
+	} {
+		attrs := make([]string, 0)
+		for _, attr := range keyAttributes(key) {
+			attrs = append(attrs, string(attr.Key)+"="+attr.Value.Emit())
+		}
+		if strings.Join(attrs, ",") != expected {
+			t.Errorf("attributes of %s should be %s, got %v", key, expected, attrs)
+		}
+	}
+}
+
+func TestInitTracingFile(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "traces.json")
+	provider, err := InitTracing(TracingConfig{Exporter: TRACING_FILE, FilePath: filePath, ServiceName: "counter-test", SampleRatio: 1})
+	if err != nil || provider == nil {
+		t.Fatal(err)
+	}
+	_, span := provider.Tracer("test").Start(context.Background(), "file-span")
+	span.End()
+	if err = provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil || !strings.Contains(string(content), "file-span") || !strings.Contains(string(content), "counter-test") {
+		t.Fail()
+	}
+
+	if _, err := InitTracing(TracingConfig{Exporter: "zipkin"}); err == nil {
+		t.Fail()
+	}
+}
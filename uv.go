@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func constructUvKey(namespace, key string) string {
+	return fmt.Sprintf("uv@%s@%s", namespace, key)
+}
+
+// identify the visitor by the given id, or by ip and user agent
+func getVisitorId(c *gin.Context) string {
+	if visitor := c.Query("visitor"); visitor != "" {
+		return visitor
+	}
+	return getClientFingerprint(c)
+}
+
+// fingerprint of ip and user agent, which the client can't choose freely
+func getClientFingerprint(c *gin.Context) string {
+	h := sha256.Sum256([]byte(c.ClientIP() + "@" + c.Request.UserAgent()))
+	return hex.EncodeToString(h[:])
+}
+
+func GetUv(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "get_uv")
+
+	namespace := c.Query("namespace")
+	if ok := checkNamespace(namespace, c); !ok {
+		return
+	}
+	if ok := isNamespaceValid(namespace, c); !ok {
+		return
+	}
+	key := c.Query("key")
+	settings, ok := isReadAllowed(namespace, key == "", c)
+	if !ok {
+		return
+	}
+
+	// get all keys under namespace
+	if key == "" {
+		newKeys, err := G_db.GetPrefixMatchKeys(ctx, constructUvKey(escapePattern(namespace), "*"))
+		if err != nil {
+			G_logger.WithContext(ctx).Warn(err)
+			errMsg := ErrorMessage{
+				Code:   5001,
+				ErrMsg: "internal error",
+			}
+			c.JSON(http.StatusInternalServerError, errMsg)
+			return
+		}
+		if len(newKeys) == 0 {
+			errMsg := ErrorMessage{
+				Code:   4001,
+				ErrMsg: "this namespace or key doesn't exist",
+			}
+			c.JSON(http.StatusBadRequest, errMsg)
+			return
+		}
+		results, err := G_db.BatchPfCount(ctx, newKeys...)
+		if err == nil {
+			err = settings.expireKeys(ctx, newKeys...)
+		}
+		if err != nil {
+			G_logger.WithContext(ctx).Warn(err)
+			errMsg := ErrorMessage{
+				Code:   5001,
+				ErrMsg: "internal error",
+			}
+			c.JSON(http.StatusInternalServerError, errMsg)
+			return
+		}
+		errMsg := ErrorMessage{
+			Code:   0,
+			ErrMsg: "successfully",
+		}
+		for _, item := range results {
+			errMsg.Data = append(errMsg.Data, Data{Key: item.key, Value: item.value})
+		}
+		c.JSON(http.StatusOK, errMsg)
+		return
+	}
+	// specific key
+	newKey := constructUvKey(namespace, key)
+	result, err := G_db.PfCount(ctx, newKey)
+	if err == nil {
+		err = settings.expireKeys(ctx, newKey)
+	}
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		if strings.Contains(err.Error(), "does not exist") {
+			errMsg := ErrorMessage{
+				Code:   4001,
+				ErrMsg: "this namespace or key doesn't exist",
+			}
+			c.JSON(http.StatusBadRequest, errMsg)
+			return
+		}
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "get key successfully",
+	}
+	errMsg.Data = append(errMsg.Data, Data{Key: result.key, Value: result.value})
+	c.JSON(http.StatusOK, errMsg)
+}
+
+func IncrementUv(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "increment_uv")
+
+	namespace := c.Query("namespace")
+	key := c.Query("key")
+	if ok := checkNamespaceAndKey(namespace, key, c); !ok {
+		return
+	}
+	if ok := isNamespaceValid(namespace, c); !ok {
+		return
+	}
+	if ok := isTokenAllowed(namespace, SCOPE_INCREMENT, c); !ok {
+		return
+	}
+
+	newKey := constructUvKey(namespace, key)
+	var result *RedisResult
+	var skipped string
+	hidden := false
+	settings, err := getSettings(ctx, namespace)
+	if err == nil {
+		hidden = !canRead(settings, namespace, c)
+		skipped, err = checkHit(settings, namespace, key, c)
+	}
+	if err == nil && skipped == "" {
+		result, err = G_db.PfAdd(ctx, newKey, getVisitorId(c))
+		if err == nil {
+			countIncrement(settings, namespace, "uv")
+			err = settings.expireKeys(ctx, newKey)
+		}
+	} else if err == nil && !hidden {
+		result, err = G_db.PfCount(ctx, newKey)
+		// nobody has been counted yet
+		if err != nil && strings.Contains(err.Error(), "does not exist") {
+			result, err = &RedisResult{key: newKey, value: int64(0)}, nil
+		}
+	}
+	// like IncrementPv, the hit is counted but nothing is told
+	if hidden {
+		respondHidden(err, c)
+		return
+	}
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: "incr key successfully",
+	}
+	if skipped != "" {
+		errMsg.ErrMsg = skipped
+	}
+	errMsg.Data = append(errMsg.Data, Data{Key: result.key, Value: result.value})
+	c.JSON(http.StatusOK, errMsg)
+}
+
+func ResetUv(c *gin.Context) {
+	ctx := c.Request.Context()
+	incrMethodCalls(ctx, "reset_uv")
+
+	namespace := c.Query("namespace")
+	key := c.Query("key")
+	if ok := checkNamespaceAndKey(namespace, key, c); !ok {
+		return
+	}
+	if ok := isAuthorized(namespace, SCOPE_RESET, c); !ok {
+		return
+	}
+
+	// a HyperLogLog can't be set to a value, so reset means clearing it
+	newKey := constructUvKey(namespace, key)
+	cnt, err := G_db.Delete(ctx, newKey)
+	if err != nil {
+		G_logger.WithContext(ctx).Warn(err)
+		errMsg := ErrorMessage{
+			Code:   5001,
+			ErrMsg: "internal error",
+		}
+		c.JSON(http.StatusInternalServerError, errMsg)
+		return
+	}
+	errMsg := ErrorMessage{
+		Code:   0,
+		ErrMsg: fmt.Sprintf("reset %d keys successfully", cnt),
+	}
+	c.JSON(http.StatusOK, errMsg)
+}
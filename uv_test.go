@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIncrementUv(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/pv/create?namespace=uvtest", nil)
+	router.ServeHTTP(w, req)
+	defer G_db.Delete(ctx, "namespace@uvtest", "settings@uvtest", "uv@uvtest@page")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/uv/increment?namespace=uvtest", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "need") {
+		t.Fail()
+	}
+
+	// the same visitor is only counted once
+	var errMsg ErrorMessage
+	for _, visitor := range []string{"alice", "bob", "alice"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/uv/increment?namespace=uvtest&key=page&visitor="+visitor, nil)
+		router.ServeHTTP(w, req)
+
+		err := json.Unmarshal(w.Body.Bytes(), &errMsg)
+		if w.Code != 200 || err != nil || errMsg.Code != 0 {
+			fmt.Println(w.Body.String())
+			t.Fail()
+		}
+	}
+	if len(errMsg.Data) != 1 || int(errMsg.Data[0].Value.(float64)) != 2 {
+		t.Fail()
+	}
+
+	// without visitor id, fallback to ip and user agent
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/uv/increment?namespace=uvtest&key=page", nil)
+		req.Header.Set("User-Agent", "test-agent")
+		router.ServeHTTP(w, req)
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &errMsg)
+	if len(errMsg.Data) != 1 || int(errMsg.Data[0].Value.(float64)) != 3 {
+		fmt.Println(w.Body.String())
+		t.Fail()
+	}
+}
+
+func TestGetAndResetUv(t *testing.T) {
+	router := MockRouters()
+	defer CleanLog()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/pv/create?namespace=uvtest", nil)
+	router.ServeHTTP(w, req)
+	defer G_db.Delete(ctx, "namespace@uvtest", "settings@uvtest", "uv@uvtest@page")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/uv/get?namespace=uvtest&key=page", nil)
+	router.ServeHTTP(w, req)
+
+	var errMsg ErrorMessage
+	_ = json.Unmarshal(w.Body.Bytes(), &errMsg)
+	if w.Code != 400 || errMsg.Code != 4001 {
+		t.Fail()
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/uv/increment?namespace=uvtest&key=page&visitor=alice", nil)
+	router.ServeHTTP(w, req)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/uv/get?namespace=uvtest", nil)
+	router.ServeHTTP(w, req)
+
+	err := json.Unmarshal(w.Body.Bytes(), &errMsg)
+	if w.Code != 200 || err != nil || len(errMsg.Data) != 1 || int(errMsg.Data[0].Value.(float64)) != 1 {
+		fmt.Println(w.Body.String())
+		t.Fail()
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/uv/reset?namespace=uvtest&secret=wrong&key=page", nil)
+	router.ServeHTTP(w, req)
+
+	_ = json.Unmarshal(w.Body.Bytes(), &errMsg)
+	if w.Code != 400 || errMsg.Code != 4002 {
+		t.Fail()
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/uv/reset?namespace=uvtest&secret=uvtest&key=page", nil)
+	router.ServeHTTP(w, req)
+
+	_ = json.Unmarshal(w.Body.Bytes(), &errMsg)
+	if w.Code != 200 || errMsg.Code != 0 {
+		t.Fail()
+	}
+
+	result, err := G_db.PfCount(ctx, "uv@uvtest@page")
+	if result != nil || err == nil {
+		t.Fail()
+	}
+
+	G_db.Delete(ctx, "call@create_pv", "call@get_uv", "call@increment_uv", "call@reset_uv")
+}